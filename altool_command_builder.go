@@ -1,8 +1,11 @@
 package main
 
 import (
+	"path/filepath"
+
 	"github.com/bitrise-io/go-utils/sliceutil"
 	"github.com/bitrise-io/go-utils/v2/log"
+	"github.com/bitrise-io/go-xcode/appleauth"
 )
 
 const (
@@ -11,9 +14,37 @@ const (
 	outputFormatKey = "--output-format"
 )
 
-func buildAltoolCommand(logger log.Logger, filePth string, packageDetails packageDetails, platform string, additionalParams []string, authParams []string, xcodeMajorVersion int64, appID string, isVerbose bool) []string {
+// altoolPasswordEnvKey is the environment variable of the altool child process holding the Apple ID password,
+// only its @env: reference is passed on the command line, so the password is not visible in the process list.
+const altoolPasswordEnvKey = "ALTOOL_APPLE_ID_PASSWORD"
+
+// altoolAuthParams returns the authentication parameters and the environment variables they reference,
+// apiKeyPath is the private key written for the current run
+func altoolAuthParams(authConfig appleauth.Credentials, apiKeyPath string, xcodeMajorVersion int64) ([]string, []string) {
+	if authConfig.APIKey != nil {
+		params := []string{"--apiKey", authConfig.APIKey.KeyID, "--apiIssuer", authConfig.APIKey.IssuerID}
+		if xcodeMajorVersion >= p8FilePathMinXcodeVersion {
+			params = append(params, "--p8-file-path", apiKeyPath)
+		}
+		return params, []string{apiPrivateKeysDirEnvKey + "=" + filepath.Dir(apiKeyPath)}
+	}
+
+	password := authConfig.AppleID.Password
+	if authConfig.AppleID.AppSpecificPassword != "" {
+		password = authConfig.AppleID.AppSpecificPassword
+	}
+
+	return []string{"--username", authConfig.AppleID.Username, "--password", "@env:" + altoolPasswordEnvKey},
+		[]string{altoolPasswordEnvKey + "=" + password}
+}
+
+// buildAltoolCommand returns the altool parameters and the environment variables of the altool process
+func buildAltoolCommand(logger log.Logger, filePth string, packageDetails packageDetails, platform string, additionalParams []string, authConfig appleauth.Credentials, apiKeyPath string, xcodeMajorVersion int64, appID string, isVerbose bool, validate bool) ([]string, []string) {
 	var uploadParams []string
-	if xcodeMajorVersion >= 26 || appID != "" {
+	if validate {
+		// Validates the package the same way as uploading it does, without uploading it
+		uploadParams = []string{"--validate-app", "-f", filePth}
+	} else if xcodeMajorVersion >= 26 || appID != "" {
 		// Use upload-package from Xcode 26, or if app ID is provided. This will cause less of a breaking change,
 		// as App ID, BundleID, Version and ShortVersion are optional in Xcode 26, but required in Xcode 16.
 		uploadParams = []string{"--upload-package", filePth}
@@ -46,9 +77,11 @@ func buildAltoolCommand(logger log.Logger, filePth string, packageDetails packag
 		additionalParams = append(additionalParams, verboseKey)
 	}
 
+	authParams, envs := altoolAuthParams(authConfig, apiKeyPath, xcodeMajorVersion)
+
 	altoolParams := append([]string{"altool"}, uploadParams...)
 	altoolParams = append(altoolParams, authParams...)
 	altoolParams = append(altoolParams, additionalParams...)
 
-	return altoolParams
+	return altoolParams, envs
 }
@@ -1,9 +1,12 @@
 package main
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/bitrise-io/go-utils/v2/log"
+	"github.com/bitrise-io/go-xcode/appleauth"
+	"github.com/bitrise-io/go-xcode/devportalservice"
 	"github.com/stretchr/testify/require"
 )
 
@@ -15,11 +18,14 @@ func Test_buildAltoolCommand(t *testing.T) {
 		packageDetails    packageDetails
 		platform          string
 		additionalParams  []string
-		authParams        []string
+		authConfig        appleauth.Credentials
+		apiKeyPath        string
 		xcodeMajorVersion int64
 		appID             string
 		isVerbose         bool
+		validate          bool
 		want              []string
+		wantEnvs          []string
 	}{
 		{
 			name:    "Xcode 26, iOS, with App ID",
@@ -31,7 +37,7 @@ func Test_buildAltoolCommand(t *testing.T) {
 			},
 			platform:          "auto",
 			additionalParams:  []string{"--team-id", "TEAMID"},
-			authParams:        []string{"-u", "user", "-p", "pass"},
+			authConfig:        appleauth.Credentials{AppleID: &appleauth.AppleID{Username: "user", Password: "hunter2"}},
 			xcodeMajorVersion: 26,
 			appID:             "1023456789",
 			isVerbose:         true,
@@ -43,11 +49,12 @@ func Test_buildAltoolCommand(t *testing.T) {
 				"--bundle-id", "com.example.app",
 				"--bundle-version", "1.0.0",
 				"--bundle-short-version-string", "1.0",
-				"-u", "user", "-p", "pass",
+				"--username", "user", "--password", "@env:ALTOOL_APPLE_ID_PASSWORD",
 				"--team-id", "TEAMID",
 				"--output-format", "json",
 				"--verbose",
 			},
+			wantEnvs: []string{"ALTOOL_APPLE_ID_PASSWORD=hunter2"},
 		},
 		{
 			name:    "Xcode 26, iOS, no App ID",
@@ -59,7 +66,7 @@ func Test_buildAltoolCommand(t *testing.T) {
 			},
 			platform:          "auto",
 			additionalParams:  []string{"--team-id", "TEAMID", "--output-format", "xml"},
-			authParams:        []string{"-u", "user", "-p", "pass"},
+			authConfig:        appleauth.Credentials{AppleID: &appleauth.AppleID{Username: "user", Password: "hunter2"}},
 			xcodeMajorVersion: 26,
 			appID:             "",
 			isVerbose:         true,
@@ -67,11 +74,12 @@ func Test_buildAltoolCommand(t *testing.T) {
 				"altool",
 				"--upload-package", "/path/to/file.ipa",
 				"--type", "ios",
-				"-u", "user", "-p", "pass",
+				"--username", "user", "--password", "@env:ALTOOL_APPLE_ID_PASSWORD",
 				"--team-id", "TEAMID",
 				"--output-format", "xml",
 				"--verbose",
 			},
+			wantEnvs: []string{"ALTOOL_APPLE_ID_PASSWORD=hunter2"},
 		},
 		{
 			name:    "Xcode 16, iOS, no App ID provided",
@@ -83,7 +91,7 @@ func Test_buildAltoolCommand(t *testing.T) {
 			},
 			platform:          "ios",
 			additionalParams:  []string{},
-			authParams:        []string{"-u", "user", "-p", "pass"},
+			authConfig:        appleauth.Credentials{AppleID: &appleauth.AppleID{Username: "user", Password: "hunter2"}},
 			xcodeMajorVersion: 16,
 			// appID:             "1023456789",
 			isVerbose: false,
@@ -91,9 +99,10 @@ func Test_buildAltoolCommand(t *testing.T) {
 				"altool",
 				"--upload-app", "-f", "/path/to/file.ipa",
 				"--type", "ios",
-				"-u", "user", "-p", "pass",
+				"--username", "user", "--password", "@env:ALTOOL_APPLE_ID_PASSWORD",
 				"--output-format", "json",
 			},
+			wantEnvs: []string{"ALTOOL_APPLE_ID_PASSWORD=hunter2"},
 		},
 		{
 			name:    "Xcode 16, iOS, with App ID",
@@ -105,7 +114,7 @@ func Test_buildAltoolCommand(t *testing.T) {
 			},
 			platform:          "ios",
 			additionalParams:  []string{},
-			authParams:        []string{"-u", "user", "-p", "pass"},
+			authConfig:        appleauth.Credentials{AppleID: &appleauth.AppleID{Username: "user", Password: "hunter2"}},
 			xcodeMajorVersion: 16,
 			appID:             "1023456789",
 			isVerbose:         false,
@@ -117,16 +126,127 @@ func Test_buildAltoolCommand(t *testing.T) {
 				"--bundle-id", "com.example.app",
 				"--bundle-version", "1.0.0",
 				"--bundle-short-version-string", "1.0",
-				"-u", "user", "-p", "pass",
+				"--username", "user", "--password", "@env:ALTOOL_APPLE_ID_PASSWORD",
 				"--output-format", "json",
 			},
+			wantEnvs: []string{"ALTOOL_APPLE_ID_PASSWORD=hunter2"},
+		},
+		{
+			name:    "Xcode 26, validate, with App ID",
+			filePth: "/path/to/file.ipa",
+			packageDetails: packageDetails{
+				bundleID:                 "com.example.app",
+				bundleVersion:            "1.0.0",
+				bundleShortVersionString: "1.0",
+			},
+			platform:          "ios",
+			authConfig:        appleauth.Credentials{APIKey: &devportalservice.APIKeyConnection{KeyID: "KEYID", IssuerID: "ISSUER", PrivateKey: testPrivateKey}},
+			apiKeyPath:        "/tmp/private_keys123/AuthKey_KEYID.p8",
+			xcodeMajorVersion: 26,
+			appID:             "1023456789",
+			validate:          true,
+			want: []string{
+				"altool",
+				"--validate-app", "-f", "/path/to/file.ipa",
+				"--type", "ios",
+				"--apple-id", "1023456789",
+				"--bundle-id", "com.example.app",
+				"--bundle-version", "1.0.0",
+				"--bundle-short-version-string", "1.0",
+				"--apiKey", "KEYID", "--apiIssuer", "ISSUER", "--p8-file-path", "/tmp/private_keys123/AuthKey_KEYID.p8",
+				"--output-format", "json",
+			},
+			wantEnvs: []string{"API_PRIVATE_KEYS_DIR=/tmp/private_keys123"},
+		},
+		{
+			name:              "Xcode 16, validate, no App ID",
+			filePth:           "/path/to/file.ipa",
+			platform:          "tvos",
+			additionalParams:  []string{"--type", "appletvos"},
+			authConfig:        appleauth.Credentials{AppleID: &appleauth.AppleID{Username: "user", Password: "hunter2"}},
+			xcodeMajorVersion: 16,
+			validate:          true,
+			want: []string{
+				"altool",
+				"--validate-app", "-f", "/path/to/file.ipa",
+				"--username", "user", "--password", "@env:ALTOOL_APPLE_ID_PASSWORD",
+				"--type", "appletvos",
+				"--output-format", "json",
+			},
+			wantEnvs: []string{"ALTOOL_APPLE_ID_PASSWORD=hunter2"},
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got := buildAltoolCommand(logger, tt.filePth, tt.packageDetails, tt.platform, tt.additionalParams, tt.authParams, tt.xcodeMajorVersion, tt.appID, tt.isVerbose)
+			got, gotEnvs := buildAltoolCommand(logger, tt.filePth, tt.packageDetails, tt.platform, tt.additionalParams, tt.authConfig, tt.apiKeyPath, tt.xcodeMajorVersion, tt.appID, tt.isVerbose, tt.validate)
 
 			require.Equal(t, tt.want, got)
+			require.Equal(t, tt.wantEnvs, gotEnvs)
+			requireNoSecret(t, got, tt.authConfig)
 		})
 	}
 }
+
+func Test_altoolAuthParams(t *testing.T) {
+	tests := []struct {
+		name              string
+		authConfig        appleauth.Credentials
+		xcodeMajorVersion int64
+		want              []string
+		wantEnvs          []string
+	}{
+		{
+			name:       "Apple ID",
+			authConfig: appleauth.Credentials{AppleID: &appleauth.AppleID{Username: "user@example.com", Password: "hunter2"}},
+			want:       []string{"--username", "user@example.com", "--password", "@env:ALTOOL_APPLE_ID_PASSWORD"},
+			wantEnvs:   []string{"ALTOOL_APPLE_ID_PASSWORD=hunter2"},
+		},
+		{
+			name:       "App-specific password is preferred",
+			authConfig: appleauth.Credentials{AppleID: &appleauth.AppleID{Username: "user@example.com", Password: "hunter2", AppSpecificPassword: "abcd-efgh-ijkl-mnop"}},
+			want:       []string{"--username", "user@example.com", "--password", "@env:ALTOOL_APPLE_ID_PASSWORD"},
+			wantEnvs:   []string{"ALTOOL_APPLE_ID_PASSWORD=abcd-efgh-ijkl-mnop"},
+		},
+		{
+			name:              "API key, Xcode 16",
+			authConfig:        appleauth.Credentials{APIKey: &devportalservice.APIKeyConnection{KeyID: "KEYID", IssuerID: "ISSUER", PrivateKey: testPrivateKey}},
+			xcodeMajorVersion: 16,
+			want:              []string{"--apiKey", "KEYID", "--apiIssuer", "ISSUER"},
+			wantEnvs:          []string{"API_PRIVATE_KEYS_DIR=/tmp/private_keys123"},
+		},
+		{
+			name:              "API key, Xcode 26",
+			authConfig:        appleauth.Credentials{APIKey: &devportalservice.APIKeyConnection{KeyID: "KEYID", IssuerID: "ISSUER", PrivateKey: testPrivateKey}},
+			xcodeMajorVersion: 26,
+			want:              []string{"--apiKey", "KEYID", "--apiIssuer", "ISSUER", "--p8-file-path", "/tmp/private_keys123/AuthKey_KEYID.p8"},
+			wantEnvs:          []string{"API_PRIVATE_KEYS_DIR=/tmp/private_keys123"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, gotEnvs := altoolAuthParams(tt.authConfig, "/tmp/private_keys123/AuthKey_KEYID.p8", tt.xcodeMajorVersion)
+
+			require.Equal(t, tt.want, got)
+			require.Equal(t, tt.wantEnvs, gotEnvs)
+			requireNoSecret(t, got, tt.authConfig)
+		})
+	}
+}
+
+// requireNoSecret checks that the command line does not contain any of the secrets
+func requireNoSecret(t *testing.T, args []string, authConfig appleauth.Credentials) {
+	var secrets []string
+	if authConfig.AppleID != nil {
+		secrets = append(secrets, authConfig.AppleID.Password, authConfig.AppleID.AppSpecificPassword)
+	}
+	if authConfig.APIKey != nil {
+		secrets = append(secrets, authConfig.APIKey.PrivateKey)
+	}
+
+	commandLine := strings.Join(args, " ")
+	for _, secret := range secrets {
+		if secret != "" {
+			require.NotContains(t, commandLine, secret)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bitrise-io/go-utils/v2/log"
+)
+
+// progressReportInterval is the minimum time between two upload progress logs
+const progressReportInterval = 15 * time.Second
+
+// lineWriter calls onLine with every complete line written to it, so the output of a running process can be streamed
+type lineWriter struct {
+	onLine func(line string)
+	buf    []byte
+}
+
+func newLineWriter(onLine func(line string)) *lineWriter {
+	return &lineWriter{onLine: onLine}
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		w.onLine(strings.TrimRight(string(w.buf[:i]), "\r"))
+		w.buf = w.buf[i+1:]
+	}
+
+	return len(p), nil
+}
+
+// flush passes the last line to onLine, if the output does not end with a newline
+func (w *lineWriter) flush() {
+	if len(w.buf) > 0 {
+		w.onLine(strings.TrimRight(string(w.buf), "\r"))
+		w.buf = nil
+	}
+}
+
+// Progress lines of altool and the Transporter, for example:
+// "Upload progress: 45.23%" or "[2025-10-01 08:00:00 UTC] <main> INFO: Transferred 1,048,576 bytes of 10,485,760 bytes".
+// The progress output format is not documented by Apple, so only lines matching these formats as a whole
+// (after an optional log prefix) are summarized, every other line is printed as-is.
+var (
+	progressLogPrefix      = `(?:\[[^\]]*\]\s*)?(?:<[^>]*>\s*)?(?:[A-Z]+:\s*)?`
+	progressPercentPattern = regexp.MustCompile(`(?i)^\s*` + progressLogPrefix + `(?:upload\s+)?progress:?\s*(\d{1,3}(?:\.\d+)?)\s*%\s*(?:\((\d[\d,]*)(?:\s*bytes)?\s+of\s+(\d[\d,]*)\s*bytes\))?\s*$`)
+	progressBytesPattern   = regexp.MustCompile(`(?i)^\s*` + progressLogPrefix + `(?:transferred|uploaded)\s+(\d[\d,]*)(?:\s*bytes)?\s+(?:of|/)\s+(\d[\d,]*)\s*bytes\.?\s*$`)
+)
+
+// uploadProgress is a progress line of the upload, transferred and total are -1 if the line has percentage only
+type uploadProgress struct {
+	percent     float64
+	transferred int64
+	total       int64
+}
+
+func parseProgressLine(line string) (uploadProgress, bool) {
+	progress := uploadProgress{percent: -1, transferred: -1, total: -1}
+
+	var transferred, total string
+	if match := progressPercentPattern.FindStringSubmatch(line); match != nil {
+		if percent, err := strconv.ParseFloat(match[1], 64); err == nil && percent <= 100 {
+			progress.percent = percent
+		}
+		transferred, total = match[2], match[3]
+	} else if match := progressBytesPattern.FindStringSubmatch(line); match != nil {
+		transferred, total = match[1], match[2]
+	}
+
+	if transferred != "" {
+		transferredBytes, transferredErr := strconv.ParseInt(strings.ReplaceAll(transferred, ",", ""), 10, 64)
+		totalBytes, totalErr := strconv.ParseInt(strings.ReplaceAll(total, ",", ""), 10, 64)
+		if transferredErr == nil && totalErr == nil && totalBytes > 0 && transferredBytes <= totalBytes {
+			progress.transferred, progress.total = transferredBytes, totalBytes
+			if progress.percent < 0 {
+				progress.percent = float64(transferredBytes) / float64(totalBytes) * 100
+			}
+		}
+	}
+
+	return progress, progress.percent >= 0
+}
+
+// progressReporter logs the upload progress with the throughput and the estimated time left,
+// at most once per interval. It is safe to use from the stdout and stderr copying goroutines.
+type progressReporter struct {
+	logger   log.Logger
+	interval time.Duration
+	now      func() time.Time
+
+	mu         sync.Mutex
+	first      *uploadProgress
+	start      time.Time
+	lastReport time.Time
+}
+
+func newProgressReporter(logger log.Logger) *progressReporter {
+	return &progressReporter{logger: logger, interval: progressReportInterval, now: time.Now}
+}
+
+// handleLine reports the line if it is a progress line, and tells whether it was one
+func (r *progressReporter) handleLine(line string) bool {
+	progress, ok := parseProgressLine(line)
+	if !ok {
+		return false
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.now()
+	if r.first == nil {
+		r.first = &progress
+		r.start = now
+	} else if now.Sub(r.lastReport) < r.interval && progress.percent < 100 {
+		return true
+	}
+	r.lastReport = now
+
+	r.logger.Printf("%s", r.report(progress, now.Sub(r.start)))
+
+	return true
+}
+
+func (r *progressReporter) report(progress uploadProgress, elapsed time.Duration) string {
+	message := fmt.Sprintf("Upload progress: %.1f%%", progress.percent)
+	if progress.total > 0 {
+		message += fmt.Sprintf(" (%s of %s)", formatBytes(progress.transferred), formatBytes(progress.total))
+	}
+	if elapsed <= 0 {
+		return message
+	}
+
+	if progress.transferred >= 0 && r.first.transferred >= 0 && progress.transferred > r.first.transferred {
+		bytesPerSecond := float64(progress.transferred-r.first.transferred) / elapsed.Seconds()
+		message += fmt.Sprintf(", %s/s", formatBytes(int64(bytesPerSecond)))
+	}
+	if done := progress.percent - r.first.percent; done > 0 && progress.percent < 100 {
+		eta := time.Duration(float64(elapsed) * (100 - progress.percent) / done)
+		message += fmt.Sprintf(", ETA %s", eta.Round(time.Second))
+	}
+
+	return message
+}
+
+// formatBytes formats a size with decimal units, like altool does
+func formatBytes(size int64) string {
+	const unit = 1000
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+
+	value := float64(size) / unit
+	for _, prefix := range []string{"kB", "MB"} {
+		if value < unit {
+			return fmt.Sprintf("%.1f %s", value, prefix)
+		}
+		value /= unit
+	}
+
+	return fmt.Sprintf("%.1f GB", value)
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/bitrise-io/go-utils/v2/log"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_lineWriter(t *testing.T) {
+	var lines []string
+	w := newLineWriter(func(line string) { lines = append(lines, line) })
+
+	for _, chunk := range []string{"first li", "ne\r\nsecond line\nthi", "rd", " line"} {
+		n, err := w.Write([]byte(chunk))
+		require.NoError(t, err)
+		require.Equal(t, len(chunk), n)
+	}
+	require.Equal(t, []string{"first line", "second line"}, lines)
+
+	w.flush()
+	require.Equal(t, []string{"first line", "second line", "third line"}, lines)
+}
+
+func Test_parseProgressLine(t *testing.T) {
+	tests := []struct {
+		name   string
+		line   string
+		want   uploadProgress
+		wantOk bool
+	}{
+		{
+			name:   "Percentage",
+			line:   "Upload progress: 45.23%",
+			want:   uploadProgress{percent: 45.23, transferred: -1, total: -1},
+			wantOk: true,
+		},
+		{
+			name:   "Transferred bytes",
+			line:   "[2025-10-01 08:00:00 UTC] <main> INFO: Transferred 1,048,576 bytes of 4,194,304 bytes",
+			want:   uploadProgress{percent: 25, transferred: 1048576, total: 4194304},
+			wantOk: true,
+		},
+		{
+			name:   "Transferred bytes and percentage",
+			line:   "Upload progress: 50% (2097152 of 4194304 bytes)",
+			want:   uploadProgress{percent: 50, transferred: 2097152, total: 4194304},
+			wantOk: true,
+		},
+		{
+			name: "Not a progress line",
+			line: "UPLOAD SUCCEEDED with no errors",
+			want: uploadProgress{percent: -1, transferred: -1, total: -1},
+		},
+		{
+			name: "Error mentioning the progress",
+			line: "ERROR: Upload failed at progress 45% (code: -19237), the server returned unexpected content",
+			want: uploadProgress{percent: -1, transferred: -1, total: -1},
+		},
+		{
+			name: "Message mentioning transferred bytes",
+			line: "Transferred 1,048,576 bytes of 4,194,304 bytes before the connection was lost",
+			want: uploadProgress{percent: -1, transferred: -1, total: -1},
+		},
+		{
+			name: "Transfer summary",
+			line: "Transferred: 19555969 bytes in 1.831 seconds (10.7MB/s, 85.437Mbps)",
+			want: uploadProgress{percent: -1, transferred: -1, total: -1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := parseProgressLine(tt.line)
+			require.Equal(t, tt.wantOk, ok)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_progressReporter(t *testing.T) {
+	var out bytes.Buffer
+	now := time.Date(2025, 10, 1, 8, 0, 0, 0, time.UTC)
+	reporter := newProgressReporter(log.NewLogger(log.WithOutput(&out)))
+	reporter.now = func() time.Time { return now }
+
+	require.True(t, reporter.handleLine("Transferred 0 bytes of 1,000,000,000 bytes"))
+	now = now.Add(5 * time.Second)
+	require.True(t, reporter.handleLine("Transferred 50,000,000 bytes of 1,000,000,000 bytes"), "reported within the interval")
+	now = now.Add(15 * time.Second)
+	require.True(t, reporter.handleLine("Transferred 200,000,000 bytes of 1,000,000,000 bytes"))
+	now = now.Add(time.Second)
+	require.True(t, reporter.handleLine("Transferred 1,000,000,000 bytes of 1,000,000,000 bytes"), "completion is always reported")
+	require.False(t, reporter.handleLine("UPLOAD SUCCEEDED"))
+
+	require.Equal(t, `Upload progress: 0.0% (0 B of 1.0 GB)
+Upload progress: 20.0% (200.0 MB of 1.0 GB), 10.0 MB/s, ETA 1m20s
+Upload progress: 100.0% (1.0 GB of 1.0 GB), 47.6 MB/s
+`, out.String())
+}
+
+func Test_formatBytes(t *testing.T) {
+	require.Equal(t, "999 B", formatBytes(999))
+	require.Equal(t, "1.5 kB", formatBytes(1500))
+	require.Equal(t, "19.6 MB", formatBytes(19555969))
+	require.Equal(t, "4.2 GB", formatBytes(4200000000))
+}
+
+func Test_altoolUploader_streamsRedactedOutput(t *testing.T) {
+	var out bytes.Buffer
+	uploader := altoolUploader{
+		ctx:    context.Background(),
+		logger: log.NewLogger(log.WithOutput(newRedactingWriter(&out, newRedactor("app-password")))),
+		name:   "sh",
+		altoolParams: []string{"-c", `echo 'Authorization: "Bearer eyJhbGciOiJ.secret"' >&2
+echo 'Upload progress: 100%' >&2
+echo 'Login with app-password failed' >&2
+echo 'UPLOAD SUCCEEDED'`, "--password", "app-password"},
+		filePth: "app.ipa",
+	}
+
+	stdOut, errorOut, _, err := uploader.upload()
+
+	require.NoError(t, err)
+	require.Equal(t, "UPLOAD SUCCEEDED\n", stdOut)
+	require.Contains(t, errorOut, "Bearer eyJhbGciOiJ.secret", "the captured output is not modified")
+	require.NotContains(t, out.String(), "eyJhbGciOiJ.secret")
+	require.NotContains(t, out.String(), "app-password")
+	require.Contains(t, out.String(), `"--password" "[REDACTED]"`)
+	require.Contains(t, out.String(), `Authorization: "Bearer [REDACTED]"`)
+	require.Contains(t, out.String(), "Login with [REDACTED] failed")
+	require.Contains(t, out.String(), "Upload progress: 100.0%")
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// altoolWaitDelay is the time allowed for the output pipes to be closed after altool is killed
+const altoolWaitDelay = 5 * time.Second
+
+// runAltool runs the command with the envs added to the step's environment, in its own process group. The whole group
+// is killed (altool spawns Java Transporter processes) when the attempt times out or ctx is cancelled,
+// the output captured until then is kept in the writers.
+func runAltool(ctx context.Context, timeout time.Duration, envs []string, stdout, stderr io.Writer, name string, args ...string) error {
+	attemptCtx := ctx
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		attemptCtx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	cmd := exec.CommandContext(attemptCtx, name, args...)
+	cmd.Env = append(os.Environ(), envs...)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		// The process group ID equals the PID of the group leader, a negative PID signals the whole group
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	cmd.WaitDelay = altoolWaitDelay
+
+	err := cmd.Run()
+	switch {
+	case ctx.Err() != nil:
+		return fmt.Errorf("altool was cancelled: %w", ctx.Err())
+	case errors.Is(attemptCtx.Err(), context.DeadlineExceeded):
+		return fmt.Errorf("altool attempt timed out after %s: %w", timeout, context.DeadlineExceeded)
+	default:
+		return err
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/bitrise-io/go-utils/v2/log"
+	"github.com/stretchr/testify/require"
+)
+
+// stuckAltool prints some output, then hangs in a child process holding the output pipes, like a stuck Transporter
+const stuckAltool = `echo "Uploading app.ipa"; echo "Transporter started" >&2; sleep 30 & wait`
+
+func Test_runAltool(t *testing.T) {
+	t.Run("Success", func(t *testing.T) {
+		var stdout, stderr bytes.Buffer
+		require.NoError(t, runAltool(context.Background(), time.Minute, nil, &stdout, &stderr, "sh", "-c", "echo done"))
+		require.Equal(t, "done\n", stdout.String())
+	})
+
+	t.Run("Environment", func(t *testing.T) {
+		var stdout, stderr bytes.Buffer
+		require.NoError(t, runAltool(context.Background(), 0, []string{altoolPasswordEnvKey + "=app-password"}, &stdout, &stderr, "sh", "-c", "echo $"+altoolPasswordEnvKey))
+		require.Equal(t, "app-password\n", stdout.String())
+	})
+
+	t.Run("Timeout kills the process group", func(t *testing.T) {
+		var stdout, stderr bytes.Buffer
+		start := time.Now()
+
+		err := runAltool(context.Background(), 500*time.Millisecond, nil, &stdout, &stderr, "sh", "-c", stuckAltool)
+
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+		require.EqualError(t, err, "altool attempt timed out after 500ms: context deadline exceeded")
+		// The pipes are closed by the killed child process, without waiting for the WaitDelay
+		require.Less(t, time.Since(start), altoolWaitDelay)
+		require.Equal(t, "Uploading app.ipa\n", stdout.String())
+		require.Equal(t, "Transporter started\n", stderr.String())
+	})
+
+	t.Run("Cancellation kills the process group", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(500*time.Millisecond, cancel)
+		var stdout, stderr bytes.Buffer
+		start := time.Now()
+
+		err := runAltool(ctx, 0, nil, &stdout, &stderr, "sh", "-c", stuckAltool)
+
+		require.ErrorIs(t, err, context.Canceled)
+		require.Less(t, time.Since(start), altoolWaitDelay)
+		require.Equal(t, "Uploading app.ipa\n", stdout.String())
+	})
+}
+
+func Test_altoolUploader_timeout(t *testing.T) {
+	uploader := altoolUploader{
+		ctx:            context.Background(),
+		logger:         log.NewLogger(),
+		name:           "sh",
+		altoolParams:   []string{"-c", stuckAltool},
+		filePth:        "app.ipa",
+		attemptTimeout: 500 * time.Millisecond,
+	}
+
+	stdOut, errorOut, _, err := uploader.upload()
+
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.Equal(t, "Uploading app.ipa\n", stdOut)
+	require.Equal(t, "Transporter started\n", errorOut)
+	require.True(t, classifyUploadError(err, altoolResult{}, errorOut).retryable)
+}
@@ -33,6 +33,8 @@ type userInfo struct {
 	// Top level only, optional:
 	NSUnderlyingError string `json:"NSUnderlyingError"`
 	IrisCode          string `json:"iris-code"`
+	// Rate limited requests only, in seconds or as an HTTP date
+	RetryAfter string `json:"Retry-After"`
 }
 
 type productError struct {
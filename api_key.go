@@ -0,0 +1,84 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+const (
+	// apiPrivateKeysDirEnvKey is the environment variable altool looks for private keys in, besides the default locations
+	apiPrivateKeysDirEnvKey = "API_PRIVATE_KEYS_DIR"
+	// p8FilePathMinXcodeVersion is the first Xcode version, whose altool accepts the private key path
+	p8FilePathMinXcodeVersion = 26
+)
+
+func apiKeyFileName(keyID string) string {
+	return fmt.Sprintf("AuthKey_%s.p8", keyID)
+}
+
+// defaultAPIKeyDirs are the directories altool looks for private keys in, see altool's man page
+func defaultAPIKeyDirs(home string) []string {
+	return []string{
+		"./private_keys",
+		filepath.Join(home, "private_keys"),
+		filepath.Join(home, ".private_keys"),
+		filepath.Join(home, ".appstoreconnect/private_keys"),
+	}
+}
+
+// apiPrivateKey is an API private key written to a per-run temporary directory, so no key is left behind
+// on the machine and a key written by an earlier build is never used.
+type apiPrivateKey struct {
+	dir  string
+	path string
+}
+
+// writeAPIPrivateKey verifies the private key, then writes it to a new temporary directory, only readable by the user
+func writeAPIPrivateKey(privateKey, keyID string) (apiPrivateKey, error) {
+	if _, err := parseAPIPrivateKey(privateKey); err != nil {
+		return apiPrivateKey{}, fmt.Errorf("invalid private key: %w", err)
+	}
+
+	dir, err := os.MkdirTemp("", "private_keys")
+	if err != nil {
+		return apiPrivateKey{}, err
+	}
+	key := apiPrivateKey{dir: dir, path: filepath.Join(dir, apiKeyFileName(keyID))}
+	if err := os.WriteFile(key.path, []byte(privateKey), 0600); err != nil {
+		return key, fmt.Errorf("failed to write private key: %w", err)
+	}
+
+	return key, nil
+}
+
+func (k apiPrivateKey) remove() error {
+	return os.RemoveAll(k.dir)
+}
+
+// conflictingAPIKeys returns the private keys in the default locations, which have the name of the provided key but
+// a different content. Older altool versions might pick these instead of the one in the temporary directory.
+func conflictingAPIKeys(privateKey, keyID string, dirs []string) ([]string, error) {
+	expected, err := parseAPIPrivateKey(privateKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid private key: %w", err)
+	}
+
+	var conflicting []string
+	for _, dir := range dirs {
+		pth := filepath.Join(dir, apiKeyFileName(keyID))
+		content, err := os.ReadFile(pth)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		if existing, err := parseAPIPrivateKey(string(content)); err != nil || !existing.Equal(expected) {
+			conflicting = append(conflicting, pth)
+		}
+	}
+
+	return conflicting, nil
+}
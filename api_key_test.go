@@ -0,0 +1,80 @@
+package main
+
+import (
+	"crypto/elliptic"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_writeAPIPrivateKey(t *testing.T) {
+	_, privateKey := generateTestAPIKey(t, elliptic.P256())
+
+	key, err := writeAPIPrivateKey(privateKey, "KEYID")
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join(key.dir, "AuthKey_KEYID.p8"), key.path)
+
+	content, err := os.ReadFile(key.path)
+	require.NoError(t, err)
+	require.Equal(t, privateKey, string(content))
+	info, err := os.Stat(key.path)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	other, err := writeAPIPrivateKey(privateKey, "KEYID")
+	require.NoError(t, err)
+	require.NotEqual(t, key.dir, other.dir, "every run writes its own key")
+
+	require.NoError(t, key.remove())
+	require.NoError(t, other.remove())
+	require.NoDirExists(t, key.dir)
+	require.NoDirExists(t, other.dir)
+}
+
+func Test_writeAPIPrivateKey_invalidKey(t *testing.T) {
+	key, err := writeAPIPrivateKey("not a private key", "KEYID")
+	require.ErrorContains(t, err, "invalid private key")
+	require.Empty(t, key.dir, "nothing is written")
+}
+
+func Test_conflictingAPIKeys(t *testing.T) {
+	_, privateKey := generateTestAPIKey(t, elliptic.P256())
+	_, otherKey := generateTestAPIKey(t, elliptic.P256())
+
+	dir := t.TempDir()
+	sameDir := filepath.Join(dir, "same")
+	differentDir := filepath.Join(dir, "different")
+	invalidDir := filepath.Join(dir, "invalid")
+	missingDir := filepath.Join(dir, "missing")
+	for pth, content := range map[string]string{
+		// The same key with Windows line endings
+		sameDir:      strings.ReplaceAll(privateKey, "\n", "\r\n"),
+		differentDir: otherKey,
+		invalidDir:   "stale",
+	} {
+		require.NoError(t, os.MkdirAll(pth, 0700))
+		require.NoError(t, os.WriteFile(filepath.Join(pth, "AuthKey_KEYID.p8"), []byte(content), 0600))
+	}
+
+	got, err := conflictingAPIKeys(privateKey, "KEYID", []string{sameDir, differentDir, invalidDir, missingDir})
+	require.NoError(t, err)
+	require.Equal(t, []string{filepath.Join(differentDir, "AuthKey_KEYID.p8"), filepath.Join(invalidDir, "AuthKey_KEYID.p8")}, got)
+
+	got, err = conflictingAPIKeys(privateKey, "OTHERID", []string{sameDir, differentDir})
+	require.NoError(t, err)
+	require.Empty(t, got)
+}
+
+func Test_runCleanups(t *testing.T) {
+	var calls []int
+	addCleanup(func() { calls = append(calls, 1) })
+	addCleanup(func() { calls = append(calls, 2) })
+
+	runCleanups()
+	runCleanups()
+
+	require.Equal(t, []int{2, 1}, calls, "cleanups run once, in reverse order")
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// bundleIDPattern matches the characters allowed in a bundle ID, so it is safe to use as a file name
+var bundleIDPattern = regexp.MustCompile(`^[A-Za-z0-9.-]+$`)
+
+// appIDCache stores the app IDs looked up by bundle ID on disk, so later builds skip the lookup.
+// Bundle IDs are unique within a team only, so the entries are stored per team.
+type appIDCache struct {
+	dir string
+}
+
+// newAppIDCache returns the cache of the team in dir, the team is the API key's issuer ID,
+// or the key ID for individual keys, which have no issuer ID
+func newAppIDCache(dir, team string) *appIDCache {
+	return &appIDCache{dir: filepath.Join(dir, team)}
+}
+
+func (c appIDCache) path(bundleID string) (string, error) {
+	if !bundleIDPattern.MatchString(bundleID) || strings.Trim(bundleID, ".") == "" {
+		return "", fmt.Errorf("invalid bundle ID: %q", bundleID)
+	}
+
+	return filepath.Join(c.dir, bundleID), nil
+}
+
+// get returns the cached app ID, a missing or unreadable entry is a cache miss
+func (c appIDCache) get(bundleID string) (string, bool) {
+	pth, err := c.path(bundleID)
+	if err != nil {
+		return "", false
+	}
+	content, err := os.ReadFile(pth)
+	if err != nil {
+		return "", false
+	}
+	appID := strings.TrimSpace(string(content))
+
+	return appID, appID != ""
+}
+
+func (c appIDCache) set(bundleID, appID string) error {
+	if appID == "" {
+		return errors.New("empty app ID")
+	}
+	pth, err := c.path(bundleID)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(c.dir, 0700); err != nil {
+		return err
+	}
+
+	// Written to a temporary file first, so a parallel upload never reads a partial entry
+	tmp, err := os.CreateTemp(c.dir, bundleID+".*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = os.Remove(tmp.Name())
+	}()
+	if _, err := tmp.WriteString(appID); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), pth)
+}
+
+// apiKeyTeam returns the identifier of the team of the API key for the app ID cache
+func apiKeyTeam(issuerID, keyID string) string {
+	if issuerID != "" {
+		return issuerID
+	}
+
+	return keyID
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_appIDCache(t *testing.T) {
+	dir := t.TempDir()
+	cache := newAppIDCache(dir, "team-a")
+
+	_, ok := cache.get("com.example.app")
+	require.False(t, ok)
+
+	require.NoError(t, cache.set("com.example.app", "1023456789"))
+	appID, ok := cache.get("com.example.app")
+	require.True(t, ok)
+	require.Equal(t, "1023456789", appID)
+
+	// Bundle IDs are unique within a team only
+	_, ok = newAppIDCache(dir, "team-b").get("com.example.app")
+	require.False(t, ok)
+
+	entries, err := os.ReadDir(filepath.Join(dir, "team-a"))
+	require.NoError(t, err)
+	require.Len(t, entries, 1, "no temporary file should be left behind")
+}
+
+func Test_appIDCache_invalidBundleID(t *testing.T) {
+	cache := newAppIDCache(t.TempDir(), "team")
+
+	for _, bundleID := range []string{"", "..", "../com.example.app", "com/example/app"} {
+		require.Error(t, cache.set(bundleID, "1023456789"), bundleID)
+		_, ok := cache.get(bundleID)
+		require.False(t, ok, bundleID)
+	}
+}
+
+func Test_apiKeyTeam(t *testing.T) {
+	require.Equal(t, "57246542-96fe-1a63-e053-0824d011072a", apiKeyTeam("57246542-96fe-1a63-e053-0824d011072a", "ABC123DEF4"))
+	require.Equal(t, "ABC123DEF4", apiKeyTeam("", "ABC123DEF4"))
+}
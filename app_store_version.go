@@ -0,0 +1,422 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/bitrise-io/go-utils/v2/log"
+)
+
+const (
+	releaseTypeUnchanged     = "unchanged"
+	releaseTypeManual        = "manual"
+	releaseTypeAfterApproval = "after_approval"
+	releaseTypeScheduled     = "scheduled"
+
+	phasedReleaseUnchanged = "unchanged"
+	phasedReleaseEnabled   = "yes"
+	phasedReleaseDisabled  = "no"
+)
+
+// editableAppStoreStates are the App Store version states in which the build and release settings can be changed
+var editableAppStoreStates = []string{
+	"PREPARE_FOR_SUBMISSION", "DEVELOPER_REJECTED", "REJECTED", "METADATA_REJECTED", "INVALID_BINARY",
+}
+
+// submittedAppStoreStates are the App Store version states of a version already submitted for review
+var submittedAppStoreStates = []string{
+	"WAITING_FOR_REVIEW", "IN_REVIEW", "PENDING_DEVELOPER_RELEASE", "PENDING_APPLE_RELEASE", "PROCESSING_FOR_APP_STORE",
+}
+
+type appStoreVersionAttributes struct {
+	Platform            string  `json:"platform,omitempty"`
+	VersionString       string  `json:"versionString,omitempty"`
+	AppStoreState       string  `json:"appStoreState,omitempty"`
+	ReleaseType         string  `json:"releaseType,omitempty"`
+	EarliestReleaseDate *string `json:"earliestReleaseDate,omitempty"`
+}
+
+type appStoreVersion struct {
+	id         string
+	attributes appStoreVersionAttributes
+}
+
+// appStoreReleaseSettings are the optional release settings of the App Store version
+type appStoreReleaseSettings struct {
+	releaseType          string
+	scheduledReleaseDate string
+	phasedRelease        string
+	// renameEditableVersion allows changing the version string of an editable version with a different version string
+	renameEditableVersion bool
+}
+
+func (s appStoreReleaseSettings) validate() error {
+	switch s.releaseType {
+	case releaseTypeUnchanged, releaseTypeManual, releaseTypeAfterApproval:
+		return nil
+	case releaseTypeScheduled:
+		if s.scheduledReleaseDate == "" {
+			return fmt.Errorf("scheduled release requires a release date")
+		}
+		if _, err := time.Parse(time.RFC3339, s.scheduledReleaseDate); err != nil {
+			return fmt.Errorf("invalid scheduled release date (%s), expected ISO 8601 format, e.g. 2025-10-01T08:00:00-07:00: %w", s.scheduledReleaseDate, err)
+		}
+		return nil
+	default:
+		return fmt.Errorf("invalid release type: %s", s.releaseType)
+	}
+}
+
+// apiReleaseType maps the release type Input to the App Store Connect API value
+func (s appStoreReleaseSettings) apiReleaseType() string {
+	switch s.releaseType {
+	case releaseTypeManual:
+		return "MANUAL"
+	case releaseTypeAfterApproval:
+		return "AFTER_APPROVAL"
+	case releaseTypeScheduled:
+		return "SCHEDULED"
+	default:
+		return ""
+	}
+}
+
+// appStoreReleaser prepares the App Store version of the uploaded build and optionally submits it for review,
+// every step checks the current state first, so a retried build does not create duplicates.
+type appStoreReleaser struct {
+	logger   log.Logger
+	client   *appStoreConnectClient
+	appID    string
+	platform platformType
+}
+
+func newAppStoreReleaser(logger log.Logger, client *appStoreConnectClient, appID string, platform platformType) appStoreReleaser {
+	return appStoreReleaser{logger: logger, client: client, appID: appID, platform: platform}
+}
+
+func (r appStoreReleaser) findAppStoreVersion(ctx context.Context, versionString string) (*appStoreVersion, error) {
+	query := url.Values{}
+	query.Set("filter[versionString]", versionString)
+
+	return r.firstAppStoreVersion(ctx, query)
+}
+
+// findEditableAppStoreVersion returns the editable App Store version of the platform, App Store Connect allows only one
+func (r appStoreReleaser) findEditableAppStoreVersion(ctx context.Context) (*appStoreVersion, error) {
+	query := url.Values{}
+	query.Set("filter[appStoreState]", strings.Join(editableAppStoreStates, ","))
+
+	return r.firstAppStoreVersion(ctx, query)
+}
+
+func (r appStoreReleaser) firstAppStoreVersion(ctx context.Context, query url.Values) (*appStoreVersion, error) {
+	query.Set("filter[platform]", apiPlatform(r.platform))
+	query.Set("fields[appStoreVersions]", "platform,versionString,appStoreState,releaseType,earliestReleaseDate")
+	query.Set("limit", "1")
+
+	var response resourceCollection
+	if err := r.client.get(ctx, "/v1/apps/"+r.appID+"/appStoreVersions?"+query.Encode(), &response); err != nil {
+		return nil, fmt.Errorf("failed to list App Store versions: %w", err)
+	}
+	if len(response.Data) == 0 {
+		return nil, nil
+	}
+
+	var attributes appStoreVersionAttributes
+	if err := json.Unmarshal(response.Data[0].Attributes, &attributes); err != nil {
+		return nil, fmt.Errorf("failed to decode App Store version: %w", err)
+	}
+
+	return &appStoreVersion{id: response.Data[0].ID, attributes: attributes}, nil
+}
+
+// findOrCreateAppStoreVersion returns the App Store version with the given version string.
+// If it does not exist yet, a new version is created. App Store Connect allows one editable version per platform,
+// an editable version with a different version string is only renamed if renameEditable is set.
+func (r appStoreReleaser) findOrCreateAppStoreVersion(ctx context.Context, versionString string, renameEditable bool) (appStoreVersion, error) {
+	version, err := r.findAppStoreVersion(ctx, versionString)
+	if err != nil {
+		return appStoreVersion{}, err
+	}
+	if version != nil {
+		r.logger.Printf("App Store version %s found (%s)", versionString, version.attributes.AppStoreState)
+		return *version, nil
+	}
+
+	// A new version can not be created while another one is editable
+	editable, err := r.findEditableAppStoreVersion(ctx)
+	if err != nil {
+		return appStoreVersion{}, err
+	}
+	if editable != nil {
+		if !renameEditable {
+			return appStoreVersion{}, uploadError{
+				description: fmt.Sprintf("App Store version %s can not be created, version %s (%s) is still editable", versionString, editable.attributes.VersionString, editable.attributes.AppStoreState),
+				reason:      fmt.Sprintf("Release or delete version %s in App Store Connect, or set rename_editable_app_store_version to rename it to %s.", editable.attributes.VersionString, versionString),
+			}
+		}
+		if err := r.updateVersionString(ctx, *editable, versionString); err != nil {
+			return appStoreVersion{}, err
+		}
+		r.logger.Printf("App Store version %s (%s) renamed to %s", editable.attributes.VersionString, editable.attributes.AppStoreState, versionString)
+		editable.attributes.VersionString = versionString
+		return *editable, nil
+	}
+
+	attributes, err := json.Marshal(appStoreVersionAttributes{
+		Platform:      apiPlatform(r.platform),
+		VersionString: versionString,
+	})
+	if err != nil {
+		return appStoreVersion{}, err
+	}
+	request := resourceDocument{Data: resource{
+		Type:       "appStoreVersions",
+		Attributes: attributes,
+		Relationships: map[string]relationship{
+			"app": {Data: resourceIdentifier{Type: "apps", ID: r.appID}},
+		},
+	}}
+	var response resourceDocument
+	if err := r.client.post(ctx, "/v1/appStoreVersions", request, &response); err != nil {
+		return appStoreVersion{}, fmt.Errorf("failed to create App Store version %s: %w", versionString, err)
+	}
+	r.logger.Printf("App Store version %s created", versionString)
+
+	created := appStoreVersion{id: response.Data.ID}
+	if len(response.Data.Attributes) > 0 {
+		if err := json.Unmarshal(response.Data.Attributes, &created.attributes); err != nil {
+			return appStoreVersion{}, fmt.Errorf("failed to decode App Store version: %w", err)
+		}
+	}
+
+	return created, nil
+}
+
+// updateVersionString sets the version string of the editable App Store version to the version of the uploaded build
+func (r appStoreReleaser) updateVersionString(ctx context.Context, version appStoreVersion, versionString string) error {
+	attributes, err := json.Marshal(appStoreVersionAttributes{VersionString: versionString})
+	if err != nil {
+		return err
+	}
+	request := resourceDocument{Data: resource{
+		Type:       "appStoreVersions",
+		ID:         version.id,
+		Attributes: attributes,
+	}}
+	if err := r.client.patch(ctx, "/v1/appStoreVersions/"+version.id, request, nil); err != nil {
+		return fmt.Errorf("failed to change the editable App Store version %s to %s, update or delete it in App Store Connect: %w", version.attributes.VersionString, versionString, err)
+	}
+
+	return nil
+}
+
+func (r appStoreReleaser) attachBuild(ctx context.Context, version appStoreVersion, buildID string) error {
+	request := relationship{Data: resourceIdentifier{Type: "builds", ID: buildID}}
+	if err := r.client.patch(ctx, "/v1/appStoreVersions/"+version.id+"/relationships/build", request, nil); err != nil {
+		return fmt.Errorf("failed to attach build to App Store version: %w", err)
+	}
+
+	return nil
+}
+
+func (r appStoreReleaser) updateReleaseType(ctx context.Context, version appStoreVersion, settings appStoreReleaseSettings) error {
+	releaseType := settings.apiReleaseType()
+	if releaseType == "" {
+		return nil
+	}
+
+	attributes := appStoreVersionAttributes{ReleaseType: releaseType}
+	if releaseType == "SCHEDULED" {
+		attributes.EarliestReleaseDate = &settings.scheduledReleaseDate
+	} else if version.attributes.ReleaseType == releaseType {
+		return nil
+	}
+
+	attributesJSON, err := json.Marshal(attributes)
+	if err != nil {
+		return err
+	}
+	request := resourceDocument{Data: resource{
+		Type:       "appStoreVersions",
+		ID:         version.id,
+		Attributes: attributesJSON,
+	}}
+	if err := r.client.patch(ctx, "/v1/appStoreVersions/"+version.id, request, nil); err != nil {
+		return fmt.Errorf("failed to update release type: %w", err)
+	}
+	r.logger.Printf("Release type set to %s", releaseType)
+
+	return nil
+}
+
+func (r appStoreReleaser) updatePhasedRelease(ctx context.Context, version appStoreVersion, phasedRelease string) error {
+	if phasedRelease == phasedReleaseUnchanged {
+		return nil
+	}
+
+	var existing optionalResourceDocument
+	if err := r.client.get(ctx, "/v1/appStoreVersions/"+version.id+"/appStoreVersionPhasedRelease", &existing); err != nil {
+		return fmt.Errorf("failed to get phased release: %w", err)
+	}
+
+	switch {
+	case phasedRelease == phasedReleaseEnabled && existing.Data == nil:
+		attributes, err := json.Marshal(map[string]string{"phasedReleaseState": "INACTIVE"})
+		if err != nil {
+			return err
+		}
+		request := resourceDocument{Data: resource{
+			Type:       "appStoreVersionPhasedReleases",
+			Attributes: attributes,
+			Relationships: map[string]relationship{
+				"appStoreVersion": {Data: resourceIdentifier{Type: "appStoreVersions", ID: version.id}},
+			},
+		}}
+		if err := r.client.post(ctx, "/v1/appStoreVersionPhasedReleases", request, nil); err != nil {
+			return fmt.Errorf("failed to enable phased release: %w", err)
+		}
+		r.logger.Printf("Phased release enabled")
+	case phasedRelease == phasedReleaseDisabled && existing.Data != nil:
+		if err := r.client.delete(ctx, "/v1/appStoreVersionPhasedReleases/"+existing.Data.ID); err != nil {
+			return fmt.Errorf("failed to disable phased release: %w", err)
+		}
+		r.logger.Printf("Phased release disabled")
+	}
+
+	return nil
+}
+
+// prepareVersion finds or creates the App Store version, attaches the build and applies the release settings
+func (r appStoreReleaser) prepareVersion(ctx context.Context, versionString, buildID string, settings appStoreReleaseSettings) (appStoreVersion, error) {
+	version, err := r.findOrCreateAppStoreVersion(ctx, versionString, settings.renameEditableVersion)
+	if err != nil {
+		return appStoreVersion{}, err
+	}
+	if slices.Contains(submittedAppStoreStates, version.attributes.AppStoreState) {
+		r.logger.Printf("App Store version %s is already submitted for review (%s), skipping changes", versionString, version.attributes.AppStoreState)
+		return version, nil
+	}
+	if version.attributes.AppStoreState != "" && !slices.Contains(editableAppStoreStates, version.attributes.AppStoreState) {
+		return appStoreVersion{}, uploadError{
+			description: fmt.Sprintf("App Store version %s can not be edited", versionString),
+			reason:      fmt.Sprintf("Version state: %s, increment the bundle short version string for a new release.", version.attributes.AppStoreState),
+		}
+	}
+
+	if err := r.attachBuild(ctx, version, buildID); err != nil {
+		return appStoreVersion{}, err
+	}
+	r.logger.Printf("Build attached to App Store version %s", versionString)
+
+	if err := r.updateReleaseType(ctx, version, settings); err != nil {
+		return appStoreVersion{}, err
+	}
+	if err := r.updatePhasedRelease(ctx, version, settings.phasedRelease); err != nil {
+		return appStoreVersion{}, err
+	}
+
+	return version, nil
+}
+
+// submitForReview adds the App Store version to a review submission and submits it,
+// a draft (not yet submitted) review submission is reused.
+func (r appStoreReleaser) submitForReview(ctx context.Context, version appStoreVersion) error {
+	if slices.Contains(submittedAppStoreStates, version.attributes.AppStoreState) {
+		r.logger.Printf("App Store version is already submitted for review")
+		return nil
+	}
+
+	submissionID, err := r.findOrCreateReviewSubmission(ctx)
+	if err != nil {
+		return err
+	}
+
+	included, err := r.reviewSubmissionContainsVersion(ctx, submissionID, version.id)
+	if err != nil {
+		return err
+	}
+	if !included {
+		request := resourceDocument{Data: resource{
+			Type: "reviewSubmissionItems",
+			Relationships: map[string]relationship{
+				"reviewSubmission": {Data: resourceIdentifier{Type: "reviewSubmissions", ID: submissionID}},
+				"appStoreVersion":  {Data: resourceIdentifier{Type: "appStoreVersions", ID: version.id}},
+			},
+		}}
+		if err := r.client.post(ctx, "/v1/reviewSubmissionItems", request, nil); err != nil {
+			return fmt.Errorf("failed to add App Store version to review submission: %w", err)
+		}
+	}
+
+	attributes, err := json.Marshal(map[string]bool{"submitted": true})
+	if err != nil {
+		return err
+	}
+	request := resourceDocument{Data: resource{
+		Type:       "reviewSubmissions",
+		ID:         submissionID,
+		Attributes: attributes,
+	}}
+	if err := r.client.patch(ctx, "/v1/reviewSubmissions/"+submissionID, request, nil); err != nil {
+		return fmt.Errorf("failed to submit for review: %w", err)
+	}
+
+	return nil
+}
+
+func (r appStoreReleaser) findOrCreateReviewSubmission(ctx context.Context) (string, error) {
+	query := url.Values{}
+	query.Set("filter[app]", r.appID)
+	query.Set("filter[platform]", apiPlatform(r.platform))
+	query.Set("filter[state]", "READY_FOR_REVIEW")
+
+	var response resourceCollection
+	if err := r.client.get(ctx, "/v1/reviewSubmissions?"+query.Encode(), &response); err != nil {
+		return "", fmt.Errorf("failed to list review submissions: %w", err)
+	}
+	if len(response.Data) > 0 {
+		return response.Data[0].ID, nil
+	}
+
+	attributes, err := json.Marshal(map[string]string{"platform": apiPlatform(r.platform)})
+	if err != nil {
+		return "", err
+	}
+	request := resourceDocument{Data: resource{
+		Type:       "reviewSubmissions",
+		Attributes: attributes,
+		Relationships: map[string]relationship{
+			"app": {Data: resourceIdentifier{Type: "apps", ID: r.appID}},
+		},
+	}}
+	var created resourceDocument
+	if err := r.client.post(ctx, "/v1/reviewSubmissions", request, &created); err != nil {
+		return "", fmt.Errorf("failed to create review submission: %w", err)
+	}
+
+	return created.Data.ID, nil
+}
+
+func (r appStoreReleaser) reviewSubmissionContainsVersion(ctx context.Context, submissionID, versionID string) (bool, error) {
+	query := url.Values{}
+	query.Set("include", "appStoreVersion")
+
+	var response resourceCollection
+	if err := r.client.get(ctx, "/v1/reviewSubmissions/"+submissionID+"/items?"+query.Encode(), &response); err != nil {
+		return false, fmt.Errorf("failed to list review submission items: %w", err)
+	}
+	for _, item := range response.Data {
+		data, ok := item.Relationships["appStoreVersion"].Data.(map[string]interface{})
+		if ok && data["id"] == versionID {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/bitrise-io/go-utils/v2/log"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_appStoreReleaseSettings_validate(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings appStoreReleaseSettings
+		wantErr  string
+	}{
+		{name: "Unchanged", settings: appStoreReleaseSettings{releaseType: releaseTypeUnchanged}},
+		{name: "Manual", settings: appStoreReleaseSettings{releaseType: releaseTypeManual}},
+		{name: "Scheduled", settings: appStoreReleaseSettings{releaseType: releaseTypeScheduled, scheduledReleaseDate: "2025-10-01T08:00:00-07:00"}},
+		{name: "Scheduled without date", settings: appStoreReleaseSettings{releaseType: releaseTypeScheduled}, wantErr: "scheduled release requires a release date"},
+		{name: "Scheduled with invalid date", settings: appStoreReleaseSettings{releaseType: releaseTypeScheduled, scheduledReleaseDate: "2025-10-01"}, wantErr: "invalid scheduled release date (2025-10-01)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.settings.validate()
+			if tt.wantErr != "" {
+				require.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
+
+type fakeAppStoreVersions struct {
+	t                 *testing.T
+	versions          string
+	editableVersions  string
+	phasedRelease     string
+	submissions       string
+	submissionItems   string
+	createdVersions   int
+	attachedBuilds    []string
+	versionUpdates    []appStoreVersionAttributes
+	phasedReleases    []string
+	createdItems      int
+	submittedReviews  []string
+	createdSubmission bool
+}
+
+func (f *fakeAppStoreVersions) server() *httptest.Server {
+	t := f.t
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/apps/app-1/appStoreVersions", func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "IOS", r.URL.Query().Get("filter[platform]"))
+		if r.URL.Query().Has("filter[appStoreState]") {
+			require.Equal(t, strings.Join(editableAppStoreStates, ","), r.URL.Query().Get("filter[appStoreState]"))
+			editableVersions := f.editableVersions
+			if editableVersions == "" {
+				editableVersions = `{"data": []}`
+			}
+			writeJSON(t, w, http.StatusOK, editableVersions)
+			return
+		}
+		require.Equal(t, "2.0.0", r.URL.Query().Get("filter[versionString]"))
+		writeJSON(t, w, http.StatusOK, f.versions)
+	})
+	mux.HandleFunc("POST /v1/appStoreVersions", func(w http.ResponseWriter, r *http.Request) {
+		f.createdVersions++
+		writeJSON(t, w, http.StatusCreated, `{"data": {"type": "appStoreVersions", "id": "version-new", "attributes": {"versionString": "2.0.0", "appStoreState": "PREPARE_FOR_SUBMISSION"}}}`)
+	})
+	mux.HandleFunc("PATCH /v1/appStoreVersions/{id}/relationships/build", func(w http.ResponseWriter, r *http.Request) {
+		var request relationship
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&request))
+		data := request.Data.(map[string]interface{})
+		f.attachedBuilds = append(f.attachedBuilds, fmt.Sprintf("%s:%s", r.PathValue("id"), data["id"]))
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("PATCH /v1/appStoreVersions/{id}", func(w http.ResponseWriter, r *http.Request) {
+		var request resourceDocument
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&request))
+		var attributes appStoreVersionAttributes
+		require.NoError(t, json.Unmarshal(request.Data.Attributes, &attributes))
+		f.versionUpdates = append(f.versionUpdates, attributes)
+		writeJSON(t, w, http.StatusOK, `{"data": {"type": "appStoreVersions", "id": "version-1"}}`)
+	})
+	mux.HandleFunc("GET /v1/appStoreVersions/{id}/appStoreVersionPhasedRelease", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(t, w, http.StatusOK, f.phasedRelease)
+	})
+	mux.HandleFunc("POST /v1/appStoreVersionPhasedReleases", func(w http.ResponseWriter, r *http.Request) {
+		f.phasedReleases = append(f.phasedReleases, "enabled")
+		writeJSON(t, w, http.StatusCreated, `{"data": {"type": "appStoreVersionPhasedReleases", "id": "phased-1"}}`)
+	})
+	mux.HandleFunc("DELETE /v1/appStoreVersionPhasedReleases/{id}", func(w http.ResponseWriter, r *http.Request) {
+		f.phasedReleases = append(f.phasedReleases, "deleted "+r.PathValue("id"))
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("GET /v1/reviewSubmissions", func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "READY_FOR_REVIEW", r.URL.Query().Get("filter[state]"))
+		writeJSON(t, w, http.StatusOK, f.submissions)
+	})
+	mux.HandleFunc("POST /v1/reviewSubmissions", func(w http.ResponseWriter, r *http.Request) {
+		f.createdSubmission = true
+		writeJSON(t, w, http.StatusCreated, `{"data": {"type": "reviewSubmissions", "id": "submission-new"}}`)
+	})
+	mux.HandleFunc("GET /v1/reviewSubmissions/{id}/items", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(t, w, http.StatusOK, f.submissionItems)
+	})
+	mux.HandleFunc("POST /v1/reviewSubmissionItems", func(w http.ResponseWriter, r *http.Request) {
+		f.createdItems++
+		writeJSON(t, w, http.StatusCreated, `{"data": {"type": "reviewSubmissionItems", "id": "item-1"}}`)
+	})
+	mux.HandleFunc("PATCH /v1/reviewSubmissions/{id}", func(w http.ResponseWriter, r *http.Request) {
+		f.submittedReviews = append(f.submittedReviews, r.PathValue("id"))
+		writeJSON(t, w, http.StatusOK, `{"data": {"type": "reviewSubmissions", "id": "submission-1"}}`)
+	})
+
+	return httptest.NewServer(mux)
+}
+
+func Test_appStoreReleaser_prepareVersion(t *testing.T) {
+	tests := []struct {
+		name               string
+		versions           string
+		editableVersions   string
+		phasedRelease      string
+		settings           appStoreReleaseSettings
+		wantCreated        int
+		wantAttached       []string
+		wantVersionUpdates []appStoreVersionAttributes
+		wantPhasedReleases []string
+		wantErr            string
+	}{
+		{
+			name:         "Creates missing version",
+			versions:     `{"data": []}`,
+			settings:     appStoreReleaseSettings{releaseType: releaseTypeUnchanged, phasedRelease: phasedReleaseUnchanged},
+			wantCreated:  1,
+			wantAttached: []string{"version-new:build-1"},
+		},
+		{
+			name:             "Leaves other editable version unchanged",
+			versions:         `{"data": []}`,
+			editableVersions: `{"data": [{"type": "appStoreVersions", "id": "version-1", "attributes": {"versionString": "1.9.0", "appStoreState": "PREPARE_FOR_SUBMISSION", "releaseType": "MANUAL"}}]}`,
+			settings:         appStoreReleaseSettings{releaseType: releaseTypeUnchanged, phasedRelease: phasedReleaseUnchanged},
+			wantErr:          "App Store version 2.0.0 can not be created, version 1.9.0 (PREPARE_FOR_SUBMISSION) is still editable",
+		},
+		{
+			name:               "Renames editable version",
+			versions:           `{"data": []}`,
+			editableVersions:   `{"data": [{"type": "appStoreVersions", "id": "version-1", "attributes": {"versionString": "1.9.0", "appStoreState": "PREPARE_FOR_SUBMISSION", "releaseType": "MANUAL"}}]}`,
+			settings:           appStoreReleaseSettings{releaseType: releaseTypeUnchanged, phasedRelease: phasedReleaseUnchanged, renameEditableVersion: true},
+			wantAttached:       []string{"version-1:build-1"},
+			wantVersionUpdates: []appStoreVersionAttributes{{VersionString: "2.0.0"}},
+		},
+		{
+			name:               "Reuses existing version and applies release settings",
+			versions:           `{"data": [{"type": "appStoreVersions", "id": "version-1", "attributes": {"versionString": "2.0.0", "appStoreState": "PREPARE_FOR_SUBMISSION", "releaseType": "MANUAL"}}]}`,
+			phasedRelease:      `{"data": null}`,
+			settings:           appStoreReleaseSettings{releaseType: releaseTypeAfterApproval, phasedRelease: phasedReleaseEnabled},
+			wantAttached:       []string{"version-1:build-1"},
+			wantVersionUpdates: []appStoreVersionAttributes{{ReleaseType: "AFTER_APPROVAL"}},
+			wantPhasedReleases: []string{"enabled"},
+		},
+		{
+			name:               "Skips unchanged release type and disables phased release",
+			versions:           `{"data": [{"type": "appStoreVersions", "id": "version-1", "attributes": {"versionString": "2.0.0", "appStoreState": "REJECTED", "releaseType": "MANUAL"}}]}`,
+			phasedRelease:      `{"data": {"type": "appStoreVersionPhasedReleases", "id": "phased-1"}}`,
+			settings:           appStoreReleaseSettings{releaseType: releaseTypeManual, phasedRelease: phasedReleaseDisabled},
+			wantAttached:       []string{"version-1:build-1"},
+			wantPhasedReleases: []string{"deleted phased-1"},
+		},
+		{
+			name:     "Scheduled release",
+			versions: `{"data": [{"type": "appStoreVersions", "id": "version-1", "attributes": {"versionString": "2.0.0", "appStoreState": "PREPARE_FOR_SUBMISSION", "releaseType": "SCHEDULED"}}]}`,
+			settings: appStoreReleaseSettings{
+				releaseType:          releaseTypeScheduled,
+				scheduledReleaseDate: "2025-10-01T08:00:00-07:00",
+				phasedRelease:        phasedReleaseUnchanged,
+			},
+			wantAttached:       []string{"version-1:build-1"},
+			wantVersionUpdates: []appStoreVersionAttributes{{ReleaseType: "SCHEDULED", EarliestReleaseDate: stringPtr("2025-10-01T08:00:00-07:00")}},
+		},
+		{
+			name:     "Already submitted version is left unchanged",
+			versions: `{"data": [{"type": "appStoreVersions", "id": "version-1", "attributes": {"versionString": "2.0.0", "appStoreState": "WAITING_FOR_REVIEW"}}]}`,
+			settings: appStoreReleaseSettings{releaseType: releaseTypeManual, phasedRelease: phasedReleaseEnabled},
+		},
+		{
+			name:     "Released version",
+			versions: `{"data": [{"type": "appStoreVersions", "id": "version-1", "attributes": {"versionString": "2.0.0", "appStoreState": "READY_FOR_SALE"}}]}`,
+			settings: appStoreReleaseSettings{releaseType: releaseTypeUnchanged, phasedRelease: phasedReleaseUnchanged},
+			wantErr:  "App Store version 2.0.0 can not be edited",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeAppStoreVersions{t: t, versions: tt.versions, editableVersions: tt.editableVersions, phasedRelease: tt.phasedRelease}
+			server := fake.server()
+			defer server.Close()
+			client := newAppStoreConnectClient(server.Client(), server.URL, staticTokenSource("test-token"))
+			releaser := newAppStoreReleaser(log.NewLogger(), client, "app-1", iOS)
+
+			_, err := releaser.prepareVersion(context.Background(), "2.0.0", "build-1", tt.settings)
+
+			if tt.wantErr != "" {
+				require.ErrorContains(t, err, tt.wantErr)
+				require.Empty(t, fake.attachedBuilds)
+				require.Empty(t, fake.versionUpdates)
+				require.Zero(t, fake.createdVersions)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.wantCreated, fake.createdVersions)
+			require.Equal(t, tt.wantAttached, fake.attachedBuilds)
+			require.Equal(t, tt.wantVersionUpdates, fake.versionUpdates)
+			require.Equal(t, tt.wantPhasedReleases, fake.phasedReleases)
+		})
+	}
+}
+
+func Test_appStoreReleaser_submitForReview(t *testing.T) {
+	tests := []struct {
+		name                  string
+		version               appStoreVersion
+		submissions           string
+		submissionItems       string
+		wantCreatedSubmission bool
+		wantCreatedItems      int
+		wantSubmitted         []string
+	}{
+		{
+			name:                  "New review submission",
+			version:               appStoreVersion{id: "version-1", attributes: appStoreVersionAttributes{AppStoreState: "PREPARE_FOR_SUBMISSION"}},
+			submissions:           `{"data": []}`,
+			submissionItems:       `{"data": []}`,
+			wantCreatedSubmission: true,
+			wantCreatedItems:      1,
+			wantSubmitted:         []string{"submission-new"},
+		},
+		{
+			name:            "Reuses draft submission already containing the version",
+			version:         appStoreVersion{id: "version-1", attributes: appStoreVersionAttributes{AppStoreState: "PREPARE_FOR_SUBMISSION"}},
+			submissions:     `{"data": [{"type": "reviewSubmissions", "id": "submission-1"}]}`,
+			submissionItems: `{"data": [{"type": "reviewSubmissionItems", "id": "item-1", "relationships": {"appStoreVersion": {"data": {"type": "appStoreVersions", "id": "version-1"}}}}]}`,
+			wantSubmitted:   []string{"submission-1"},
+		},
+		{
+			name:    "Already submitted",
+			version: appStoreVersion{id: "version-1", attributes: appStoreVersionAttributes{AppStoreState: "IN_REVIEW"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeAppStoreVersions{t: t, submissions: tt.submissions, submissionItems: tt.submissionItems}
+			server := fake.server()
+			defer server.Close()
+			client := newAppStoreConnectClient(server.Client(), server.URL, staticTokenSource("test-token"))
+			releaser := newAppStoreReleaser(log.NewLogger(), client, "app-1", iOS)
+
+			err := releaser.submitForReview(context.Background(), tt.version)
+
+			require.NoError(t, err)
+			require.Equal(t, tt.wantCreatedSubmission, fake.createdSubmission)
+			require.Equal(t, tt.wantCreatedItems, fake.createdItems)
+			require.Equal(t, tt.wantSubmitted, fake.submittedReviews)
+		})
+	}
+}
+
+func stringPtr(s string) *string {
+	return &s
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+
+	"github.com/bitrise-io/go-utils/v2/log"
+)
+
+type resourceCollection struct {
+	Data []resource `json:"data"`
+}
+
+type appAttributes struct {
+	BundleID string `json:"bundleId"`
+}
+
+// appNotFoundError is returned if the team has no app with the bundle ID
+type appNotFoundError struct {
+	bundleID string
+}
+
+func (e appNotFoundError) Error() string {
+	return fmt.Sprintf("no app found in App Store Connect with bundle ID %s\n"+
+		"Create the app in App Store Connect (Apps > New App) with this bundle ID, or check that the API key belongs to the team of the app. "+
+		"The app ID can also be set with the app_id Input.", e.bundleID)
+}
+
+// findAppID returns the App Store Connect Apple ID of the app with the given bundle ID
+func findAppID(ctx context.Context, client *appStoreConnectClient, bundleID string) (string, error) {
+	query := url.Values{}
+	query.Set("filter[bundleId]", bundleID)
+	query.Set("fields[apps]", "bundleId")
+
+	var response resourceCollection
+	if err := client.get(ctx, "/v1/apps?"+query.Encode(), &response); err != nil {
+		return "", fmt.Errorf("failed to look up app with bundle ID %s: %w", bundleID, err)
+	}
+
+	// The filter also matches apps with a bundle ID starting with the given one
+	for _, app := range response.Data {
+		var attributes appAttributes
+		if err := json.Unmarshal(app.Attributes, &attributes); err != nil {
+			return "", fmt.Errorf("failed to decode app: %w", err)
+		}
+		if attributes.BundleID == bundleID {
+			return app.ID, nil
+		}
+	}
+
+	return "", appNotFoundError{bundleID: bundleID}
+}
+
+// lookUpAppID returns the app ID of the bundle ID from the cache, or looks it up and stores it in the cache,
+// cache is nil if caching is disabled
+func lookUpAppID(ctx context.Context, logger log.Logger, client *appStoreConnectClient, cache *appIDCache, bundleID string) (string, error) {
+	if cache != nil {
+		if appID, ok := cache.get(bundleID); ok {
+			logger.Printf("App ID of %s (cached): %s", bundleID, appID)
+			return appID, nil
+		}
+	}
+
+	appID, err := findAppID(ctx, client, bundleID)
+	if err != nil {
+		return "", err
+	}
+	logger.Printf("App ID of %s: %s", bundleID, appID)
+
+	if cache != nil {
+		if err := cache.set(bundleID, appID); err != nil {
+			logger.Warnf("Failed to cache the app ID: %s", err)
+		}
+	}
+
+	return appID, nil
+}
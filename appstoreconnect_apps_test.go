@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bitrise-io/go-utils/v2/log"
+	"github.com/stretchr/testify/require"
+)
+
+const appsResponse = `{"data": [
+	{"type": "apps", "id": "1111111111", "attributes": {"bundleId": "com.example.app.widget"}},
+	{"type": "apps", "id": "1023456789", "attributes": {"bundleId": "com.example.app"}}
+]}`
+
+func newFakeAppsAPI(t *testing.T, lookups *int) *appStoreConnectClient {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/v1/apps", r.URL.Path)
+		*lookups++
+		if r.URL.Query().Get("filter[bundleId]") == "com.example.app" {
+			writeJSON(t, w, http.StatusOK, appsResponse)
+			return
+		}
+		writeJSON(t, w, http.StatusOK, `{"data": []}`)
+	}))
+	t.Cleanup(server.Close)
+
+	return newAppStoreConnectClient(server.Client(), server.URL, staticTokenSource("test-token"))
+}
+
+func Test_findAppID(t *testing.T) {
+	var lookups int
+	client := newFakeAppsAPI(t, &lookups)
+
+	appID, err := findAppID(context.Background(), client, "com.example.app")
+	require.NoError(t, err)
+	require.Equal(t, "1023456789", appID)
+
+	_, err = findAppID(context.Background(), client, "com.example.missing")
+	var notFoundErr appNotFoundError
+	require.ErrorAs(t, err, &notFoundErr)
+	require.Contains(t, err.Error(), "no app found in App Store Connect with bundle ID com.example.missing")
+	require.Contains(t, err.Error(), "app_id Input")
+}
+
+func Test_lookUpAppID(t *testing.T) {
+	var lookups int
+	client := newFakeAppsAPI(t, &lookups)
+	cache := newAppIDCache(t.TempDir(), "team")
+
+	for i := 0; i < 2; i++ {
+		appID, err := lookUpAppID(context.Background(), log.NewLogger(), client, cache, "com.example.app")
+		require.NoError(t, err)
+		require.Equal(t, "1023456789", appID)
+	}
+	require.Equal(t, 1, lookups, "the second lookup should be served from the cache")
+
+	_, err := lookUpAppID(context.Background(), log.NewLogger(), client, cache, "com.example.missing")
+	require.ErrorAs(t, err, &appNotFoundError{})
+	_, ok := cache.get("com.example.missing")
+	require.False(t, ok, "a missing app should not be cached")
+
+	appID, err := lookUpAppID(context.Background(), log.NewLogger(), client, nil, "com.example.app")
+	require.NoError(t, err)
+	require.Equal(t, "1023456789", appID)
+	require.Equal(t, 3, lookups)
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	httpretry "github.com/bitrise-io/go-utils/retry"
+	cleanhttp "github.com/hashicorp/go-cleanhttp"
+	retryablehttp "github.com/hashicorp/go-retryablehttp"
+)
+
+const appStoreConnectAPIURL = "https://api.appstoreconnect.apple.com"
+
+// apiResponseTimeout limits the wait for the response of a request, once its body (for example an upload part) is sent
+const apiResponseTimeout = 2 * time.Minute
+
+type tokenSource interface {
+	token() (string, error)
+}
+
+// appStoreConnectClient is a minimal App Store Connect API client, it only covers the endpoints used by the Step.
+type appStoreConnectClient struct {
+	httpClient *http.Client
+	baseURL    string
+	tokens     tokenSource
+}
+
+func newAppStoreConnectClient(httpClient *http.Client, baseURL string, tokens tokenSource) *appStoreConnectClient {
+	return &appStoreConnectClient{
+		httpClient: httpClient,
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		tokens:     tokens,
+	}
+}
+
+// newAPIHTTPClient returns the HTTP client of the App Store Connect API. Only idempotent requests are retried,
+// a retried POST could create a duplicate resource, for example a second build upload.
+func newAPIHTTPClient() *http.Client {
+	transport := cleanhttp.DefaultPooledTransport()
+	transport.ResponseHeaderTimeout = apiResponseTimeout
+
+	retryingClient := httpretry.NewHTTPClient()
+	retryingClient.HTTPClient = &http.Client{Transport: transport}
+
+	return &http.Client{Transport: idempotentRetryTransport{
+		retrying: &retryablehttp.RoundTripper{Client: retryingClient},
+		single:   transport,
+	}}
+}
+
+// idempotentRetryTransport sends idempotent requests with the retrying transport, and other requests only once
+type idempotentRetryTransport struct {
+	retrying http.RoundTripper
+	single   http.RoundTripper
+}
+
+func (t idempotentRetryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return t.retrying.RoundTrip(req)
+	default:
+		return t.single.RoundTrip(req)
+	}
+}
+
+// sleepContext waits for the duration, it returns early with the context's error if the context is done
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// apiErrorItem is a JSON:API error object returned by App Store Connect
+//
+//	{
+//	  "id" : "b753c995-ba50-4213-a173-fe74e14f0b48",
+//	  "status" : "409",
+//	  "code" : "STATE_ERROR.VALIDATION_ERROR",
+//	  "title" : "Validation failed",
+//	  "detail" : "Upload limit reached. The upload limit for your application has been reached. Please wait 1 day and try again."
+//	}
+type apiErrorItem struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+	Code   string `json:"code"`
+	Title  string `json:"title"`
+	Detail string `json:"detail"`
+}
+
+type apiError struct {
+	statusCode int
+	errors     []apiErrorItem
+	// retryAfter is the Retry-After header of rate limited and unavailable responses
+	retryAfter string
+}
+
+// productErrors converts the API errors to the altool JSON output format,
+// so they are reported the same way as altool errors.
+func (e apiError) productErrors() []productError {
+	if len(e.errors) == 0 {
+		return []productError{{
+			Code:    e.statusCode,
+			Message: http.StatusText(e.statusCode),
+			UserInfo: userInfo{
+				NSLocalizedDescription: http.StatusText(e.statusCode),
+				Status:                 strconv.Itoa(e.statusCode),
+				RetryAfter:             e.retryAfter,
+			},
+		}}
+	}
+
+	var productErrors []productError
+	for _, item := range e.errors {
+		productErrors = append(productErrors, productError{
+			Code:    e.statusCode,
+			Message: item.Title,
+			UserInfo: userInfo{
+				NSLocalizedDescription:   item.Title,
+				NSLocalizedFailureReason: item.Detail,
+				Code:                     item.Code,
+				Detail:                   item.Detail,
+				ID:                       item.ID,
+				Status:                   item.Status,
+				Title:                    item.Title,
+				IrisCode:                 item.Code,
+				RetryAfter:               e.retryAfter,
+			},
+		})
+	}
+
+	return productErrors
+}
+
+func (e apiError) Error() string {
+	productErrors := e.productErrors()
+	firstErr := newUploadErrorFromProductError(productErrors[0])
+	if len(productErrors) == 1 {
+		return firstErr.Error()
+	}
+	return fmt.Sprintf("%d errors, first: %s", len(productErrors), firstErr)
+}
+
+// resource is a JSON:API resource object, attributes are decoded by the caller.
+type resource struct {
+	Type          string                  `json:"type"`
+	ID            string                  `json:"id,omitempty"`
+	Attributes    json.RawMessage         `json:"attributes,omitempty"`
+	Relationships map[string]relationship `json:"relationships,omitempty"`
+}
+
+type resourceIdentifier struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+// relationship data is either a single resourceIdentifier or a list of them
+type relationship struct {
+	Data interface{} `json:"data,omitempty"`
+}
+
+func (c *appStoreConnectClient) get(ctx context.Context, path string, out interface{}) error {
+	return c.do(ctx, http.MethodGet, path, nil, out)
+}
+
+func (c *appStoreConnectClient) post(ctx context.Context, path string, in, out interface{}) error {
+	return c.do(ctx, http.MethodPost, path, in, out)
+}
+
+func (c *appStoreConnectClient) patch(ctx context.Context, path string, in, out interface{}) error {
+	return c.do(ctx, http.MethodPatch, path, in, out)
+}
+
+func (c *appStoreConnectClient) delete(ctx context.Context, path string) error {
+	return c.do(ctx, http.MethodDelete, path, nil, nil)
+}
+
+func (c *appStoreConnectClient) do(ctx context.Context, method, path string, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return fmt.Errorf("failed to encode request body: %w", err)
+		}
+		body = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, body)
+	if err != nil {
+		return err
+	}
+
+	token, err := c.tokens.token()
+	if err != nil {
+		return fmt.Errorf("failed to create App Store Connect API token: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s %s failed: %w", method, path, err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response of %s %s: %w", method, path, err)
+	}
+
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		var errorResponse struct {
+			Errors []apiErrorItem `json:"errors"`
+		}
+		// Non JSON:API error bodies (e.g. from a proxy) are reported by status code only
+		_ = json.Unmarshal(respBody, &errorResponse)
+
+		return apiError{statusCode: resp.StatusCode, errors: errorResponse.Errors, retryAfter: resp.Header.Get("Retry-After")}
+	}
+
+	if out == nil || len(respBody) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(respBody, out); err != nil {
+		return fmt.Errorf("failed to decode response of %s %s: %w", method, path, err)
+	}
+
+	return nil
+}
+
+type uploadOperationHeader struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type uploadOperation struct {
+	Method         string                  `json:"method"`
+	URL            string                  `json:"url"`
+	Length         int64                   `json:"length"`
+	Offset         int64                   `json:"offset"`
+	RequestHeaders []uploadOperationHeader `json:"requestHeaders"`
+}
+
+// uploadPart sends a single file chunk to the (pre-authorized) URL of the upload operation,
+// no App Store Connect API token is sent.
+func (c *appStoreConnectClient) uploadPart(ctx context.Context, operation uploadOperation, data []byte) error {
+	req, err := http.NewRequestWithContext(ctx, operation.Method, operation.URL, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	for _, header := range operation.RequestHeaders {
+		req.Header.Set(header.Name, header.Value)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("uploading part at offset %d failed: %w", operation.Offset, err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		return apiError{statusCode: resp.StatusCode}
+	}
+
+	return nil
+}
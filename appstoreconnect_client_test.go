@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type recordingRoundTripper struct {
+	methods []string
+}
+
+func (r *recordingRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	r.methods = append(r.methods, req.Method)
+	return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+}
+
+func Test_idempotentRetryTransport(t *testing.T) {
+	tests := []struct {
+		method    string
+		wantRetry bool
+	}{
+		{method: http.MethodGet, wantRetry: true},
+		{method: http.MethodPut, wantRetry: true},
+		{method: http.MethodDelete, wantRetry: true},
+		{method: http.MethodPost, wantRetry: false},
+		{method: http.MethodPatch, wantRetry: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method, func(t *testing.T) {
+			retrying, single := &recordingRoundTripper{}, &recordingRoundTripper{}
+			transport := idempotentRetryTransport{retrying: retrying, single: single}
+
+			req, err := http.NewRequest(tt.method, "https://api.appstoreconnect.apple.com/v1/apps", nil)
+			require.NoError(t, err)
+			_, err = transport.RoundTrip(req)
+			require.NoError(t, err)
+
+			if tt.wantRetry {
+				require.Equal(t, []string{tt.method}, retrying.methods)
+				require.Empty(t, single.methods)
+			} else {
+				require.Empty(t, retrying.methods)
+				require.Equal(t, []string{tt.method}, single.methods)
+			}
+		})
+	}
+}
+
+func Test_sleepContext(t *testing.T) {
+	require.NoError(t, sleepContext(context.Background(), time.Millisecond))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	require.ErrorIs(t, sleepContext(ctx, time.Hour), context.Canceled)
+}
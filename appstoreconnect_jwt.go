@@ -0,0 +1,148 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/bitrise-io/go-xcode/devportalservice"
+)
+
+const (
+	appStoreConnectAudience = "appstoreconnect-v1"
+	// App Store Connect rejects tokens with an expiration time more than 20 minutes in the future
+	apiTokenLifetime = 20 * time.Minute
+	// Tokens are renewed this long before they expire, so a long upload does not send an expired token
+	apiTokenRefreshMargin = 2 * time.Minute
+)
+
+// jwtSigner mints short-lived ES256 App Store Connect API tokens (JWT) from an API key,
+// the token is cached and renewed automatically before it expires.
+//
+// Team keys are identified by the issuer ID (iss claim), individual keys have no issuer ID and use the `sub: user` claim.
+// See: https://developer.apple.com/documentation/appstoreconnectapi/generating-tokens-for-api-requests
+type jwtSigner struct {
+	keyID      string
+	issuerID   string
+	privateKey *ecdsa.PrivateKey
+	// scope optionally limits the token to the listed requests, e.g. "GET /v1/apps?filter[platform]=IOS"
+	scope []string
+	now   func() time.Time
+
+	mu          sync.Mutex
+	cachedToken string
+	expiresAt   time.Time
+}
+
+func newJWTSigner(apiKey devportalservice.APIKeyConnection, scope ...string) (*jwtSigner, error) {
+	privateKey, err := parseAPIPrivateKey(apiKey.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &jwtSigner{
+		keyID:      apiKey.KeyID,
+		issuerID:   apiKey.IssuerID,
+		privateKey: privateKey,
+		scope:      scope,
+		now:        time.Now,
+	}, nil
+}
+
+// parseAPIPrivateKey parses the PEM encoded PKCS#8 EC private key (.p8 file) of an App Store Connect API key
+func parseAPIPrivateKey(privateKey string) (*ecdsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(privateKey))
+	if block == nil {
+		return nil, fmt.Errorf("private key is not PEM encoded")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("private key is not a PKCS#8 key: %w", err)
+	}
+	ecKey, ok := key.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("private key is not an EC key (%T)", key)
+	}
+	if ecKey.Curve != elliptic.P256() {
+		return nil, fmt.Errorf("private key is not a P-256 EC key (%s)", ecKey.Curve.Params().Name)
+	}
+
+	return ecKey, nil
+}
+
+func (s *jwtSigner) token() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	if s.cachedToken != "" && now.Before(s.expiresAt.Add(-apiTokenRefreshMargin)) {
+		return s.cachedToken, nil
+	}
+
+	expiresAt := now.Add(apiTokenLifetime)
+	token, err := s.sign(now, expiresAt)
+	if err != nil {
+		return "", err
+	}
+	s.cachedToken = token
+	s.expiresAt = expiresAt
+
+	return token, nil
+}
+
+func (s *jwtSigner) sign(issuedAt, expiresAt time.Time) (string, error) {
+	header := map[string]string{
+		"alg": "ES256",
+		"kid": s.keyID,
+		"typ": "JWT",
+	}
+	claims := map[string]interface{}{
+		"aud": appStoreConnectAudience,
+		"iat": issuedAt.Unix(),
+		"exp": expiresAt.Unix(),
+	}
+	if s.issuerID != "" {
+		claims["iss"] = s.issuerID
+	} else {
+		// Individual API key
+		claims["sub"] = "user"
+	}
+	if len(s.scope) > 0 {
+		claims["scope"] = s.scope
+	}
+
+	return signES256(header, claims, s.privateKey)
+}
+
+func signES256(header map[string]string, claims map[string]interface{}, key *ecdsa.PrivateKey) (string, error) {
+	headerJSON, err := json.Marshal(header)
+	if err != nil {
+		return "", err
+	}
+	claimsJSON, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(headerJSON) + "." + base64.RawURLEncoding.EncodeToString(claimsJSON)
+	digest := sha256.Sum256([]byte(signingInput))
+	r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+	if err != nil {
+		return "", fmt.Errorf("failed to sign token: %w", err)
+	}
+
+	// JWS ES256 signature is the fixed size R || S pair, not ASN.1 DER
+	signature := make([]byte, 64)
+	r.FillBytes(signature[:32])
+	s.FillBytes(signature[32:])
+
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
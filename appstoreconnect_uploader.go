@@ -0,0 +1,344 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/bitrise-io/go-utils/v2/log"
+)
+
+const (
+	buildUploadStateAwaitingUpload = "AWAITING_UPLOAD"
+	buildUploadStateFailed         = "FAILED"
+)
+
+// buildUploadStateDetail is an error, warning or info message of a build upload
+type buildUploadStateDetail struct {
+	Code        string `json:"code"`
+	Description string `json:"description"`
+}
+
+type buildUploadState struct {
+	State    string                   `json:"state"`
+	Errors   []buildUploadStateDetail `json:"errors"`
+	Warnings []buildUploadStateDetail `json:"warnings"`
+}
+
+type buildUploadAttributes struct {
+	CFBundleShortVersionString string            `json:"cfBundleShortVersionString,omitempty"`
+	CFBundleVersion            string            `json:"cfBundleVersion,omitempty"`
+	Platform                   string            `json:"platform,omitempty"`
+	State                      *buildUploadState `json:"state,omitempty"`
+}
+
+type checksum struct {
+	Hash      string `json:"hash"`
+	Algorithm string `json:"algorithm"`
+}
+
+type buildUploadFileAttributes struct {
+	AssetType           string              `json:"assetType,omitempty"`
+	FileName            string              `json:"fileName,omitempty"`
+	FileSize            int64               `json:"fileSize,omitempty"`
+	UTI                 string              `json:"uti,omitempty"`
+	UploadOperations    []uploadOperation   `json:"uploadOperations,omitempty"`
+	Uploaded            bool                `json:"uploaded,omitempty"`
+	SourceFileChecksums map[string]checksum `json:"sourceFileChecksums,omitempty"`
+}
+
+type resourceDocument struct {
+	Data resource `json:"data"`
+}
+
+// appStoreConnectUploader uploads the package with the App Store Connect API build upload flow,
+// it does not depend on Xcode or altool.
+type appStoreConnectUploader struct {
+	ctx            context.Context
+	logger         log.Logger
+	client         *appStoreConnectClient
+	filePth        string
+	appID          string
+	packageDetails packageDetails
+	platform       platformType
+
+	statePollInterval time.Duration
+	statePollTimeout  time.Duration
+}
+
+func newAppStoreConnectUploader(ctx context.Context, logger log.Logger, client *appStoreConnectClient, filePth, appID string, packageDetails packageDetails, platform platformType, statePollInterval, statePollTimeout time.Duration) uploader {
+	return appStoreConnectUploader{
+		ctx:               ctx,
+		logger:            logger,
+		client:            client,
+		filePth:           filePth,
+		appID:             appID,
+		packageDetails:    packageDetails,
+		platform:          platform,
+		statePollInterval: statePollInterval,
+		statePollTimeout:  statePollTimeout,
+	}
+}
+
+// apiPlatform maps the altool platform type to the App Store Connect API platform
+func apiPlatform(platform platformType) string {
+	switch platform {
+	case macOS:
+		return "MAC_OS"
+	case tvOS:
+		return "TV_OS"
+	default:
+		return "IOS"
+	}
+}
+
+func (a appStoreConnectUploader) upload() (string, string, altoolResult, error) {
+	fileName := filepath.Base(a.filePth)
+	a.logger.Infof("Uploading - %s ...", fileName)
+
+	result, err := a.uploadPackage()
+	var apiErr apiError
+	if errors.As(err, &apiErr) {
+		result.ProductErrors = apiErr.productErrors()
+	}
+
+	// The result is returned in the altool JSON format, only visible in debug mode
+	stdOut, jsonErr := json.MarshalIndent(result, "", "  ")
+	if jsonErr != nil {
+		a.logger.Warnf("Failed to encode upload result: %s", jsonErr)
+	}
+
+	if err != nil {
+		return string(stdOut), err.Error(), result, err
+	}
+	return string(stdOut), "", result, nil
+}
+
+func (a appStoreConnectUploader) uploadPackage() (altoolResult, error) {
+	file, err := os.Open(a.filePth)
+	if err != nil {
+		return altoolResult{}, err
+	}
+	defer func() {
+		if err := file.Close(); err != nil {
+			a.logger.Warnf("Failed to close %s: %s", a.filePth, err)
+		}
+	}()
+
+	info, err := file.Stat()
+	if err != nil {
+		return altoolResult{}, err
+	}
+
+	buildUploadID, err := a.createBuildUpload()
+	if err != nil {
+		return altoolResult{}, fmt.Errorf("failed to create build upload: %w", err)
+	}
+	a.logger.Debugf("Build upload created: %s", buildUploadID)
+
+	fileID, operations, err := a.reserveBuildUploadFile(buildUploadID, filepath.Base(a.filePth), info.Size())
+	if err != nil {
+		return altoolResult{}, fmt.Errorf("failed to reserve build upload file: %w", err)
+	}
+
+	startTime := time.Now()
+	for i, operation := range operations {
+		a.logger.Debugf("Uploading part %d/%d (%d bytes)", i+1, len(operations), operation.Length)
+
+		data := make([]byte, operation.Length)
+		if _, err := io.ReadFull(io.NewSectionReader(file, operation.Offset, operation.Length), data); err != nil {
+			return altoolResult{}, fmt.Errorf("failed to read part at offset %d: %w", operation.Offset, err)
+		}
+		if err := a.client.uploadPart(a.ctx, operation, data); err != nil {
+			return altoolResult{}, err
+		}
+	}
+	uploadDuration := time.Since(startTime)
+
+	fileChecksum, err := md5Checksum(file)
+	if err != nil {
+		return altoolResult{}, fmt.Errorf("failed to calculate checksum: %w", err)
+	}
+	if err := a.commitBuildUploadFile(fileID, fileChecksum); err != nil {
+		return altoolResult{}, fmt.Errorf("failed to commit build upload file: %w", err)
+	}
+
+	state, err := a.waitForBuildUploadState(buildUploadID)
+	if err != nil {
+		return altoolResult{}, err
+	}
+
+	result := altoolResult{
+		ProductErrors: productErrorsFromStateDetails(state.Errors),
+		Warnings:      productErrorsFromStateDetails(state.Warnings),
+	}
+	if state.State == buildUploadStateFailed {
+		return result, result.getError()
+	}
+
+	result.SuccessMessage = fmt.Sprintf("No errors uploading archive at '%s'.", a.filePth)
+	result.SuccessDetails = successDetails{
+		DeliveryUUID: buildUploadID,
+		Transferred:  transferStats(info.Size(), uploadDuration),
+	}
+
+	return result, nil
+}
+
+func (a appStoreConnectUploader) createBuildUpload() (string, error) {
+	attributes, err := json.Marshal(buildUploadAttributes{
+		CFBundleShortVersionString: a.packageDetails.bundleShortVersionString,
+		CFBundleVersion:            a.packageDetails.bundleVersion,
+		Platform:                   apiPlatform(a.platform),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	request := resourceDocument{Data: resource{
+		Type:       "buildUploads",
+		Attributes: attributes,
+		Relationships: map[string]relationship{
+			"app": {Data: resourceIdentifier{Type: "apps", ID: a.appID}},
+		},
+	}}
+	var response resourceDocument
+	if err := a.client.post(a.ctx, "/v1/buildUploads", request, &response); err != nil {
+		return "", err
+	}
+
+	return response.Data.ID, nil
+}
+
+func (a appStoreConnectUploader) reserveBuildUploadFile(buildUploadID, fileName string, fileSize int64) (string, []uploadOperation, error) {
+	uti := "com.apple.ipa"
+	if filepath.Ext(fileName) == ".pkg" {
+		uti = "com.apple.pkg"
+	}
+
+	attributes, err := json.Marshal(buildUploadFileAttributes{
+		AssetType: "ASSET",
+		FileName:  fileName,
+		FileSize:  fileSize,
+		UTI:       uti,
+	})
+	if err != nil {
+		return "", nil, err
+	}
+
+	request := resourceDocument{Data: resource{
+		Type:       "buildUploadFiles",
+		Attributes: attributes,
+		Relationships: map[string]relationship{
+			"buildUpload": {Data: resourceIdentifier{Type: "buildUploads", ID: buildUploadID}},
+		},
+	}}
+	var response resourceDocument
+	if err := a.client.post(a.ctx, "/v1/buildUploadFiles", request, &response); err != nil {
+		return "", nil, err
+	}
+
+	var responseAttributes buildUploadFileAttributes
+	if err := json.Unmarshal(response.Data.Attributes, &responseAttributes); err != nil {
+		return "", nil, fmt.Errorf("failed to decode upload operations: %w", err)
+	}
+	if len(responseAttributes.UploadOperations) == 0 {
+		return "", nil, fmt.Errorf("no upload operations returned")
+	}
+
+	return response.Data.ID, responseAttributes.UploadOperations, nil
+}
+
+func (a appStoreConnectUploader) commitBuildUploadFile(fileID, fileChecksum string) error {
+	attributes, err := json.Marshal(buildUploadFileAttributes{
+		Uploaded: true,
+		SourceFileChecksums: map[string]checksum{
+			"file": {Hash: fileChecksum, Algorithm: "MD5"},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	request := resourceDocument{Data: resource{
+		Type:       "buildUploadFiles",
+		ID:         fileID,
+		Attributes: attributes,
+	}}
+
+	return a.client.patch(a.ctx, "/v1/buildUploadFiles/"+fileID, request, nil)
+}
+
+// waitForBuildUploadState polls the build upload until App Store Connect confirms that the file was received
+func (a appStoreConnectUploader) waitForBuildUploadState(buildUploadID string) (buildUploadState, error) {
+	deadline := time.Now().Add(a.statePollTimeout)
+	for {
+		var response resourceDocument
+		if err := a.client.get(a.ctx, "/v1/buildUploads/"+buildUploadID, &response); err != nil {
+			return buildUploadState{}, fmt.Errorf("failed to query build upload state: %w", err)
+		}
+
+		var attributes buildUploadAttributes
+		if err := json.Unmarshal(response.Data.Attributes, &attributes); err != nil {
+			return buildUploadState{}, fmt.Errorf("failed to decode build upload state: %w", err)
+		}
+		if attributes.State != nil && attributes.State.State != buildUploadStateAwaitingUpload {
+			return *attributes.State, nil
+		}
+
+		if time.Now().After(deadline) {
+			return buildUploadState{}, fmt.Errorf("build upload (%s) was not confirmed by App Store Connect in %s", buildUploadID, a.statePollTimeout)
+		}
+		if err := sleepContext(a.ctx, a.statePollInterval); err != nil {
+			return buildUploadState{}, err
+		}
+	}
+}
+
+func productErrorsFromStateDetails(details []buildUploadStateDetail) []productError {
+	var productErrors []productError
+	for _, detail := range details {
+		productErrors = append(productErrors, productError{
+			Message: detail.Description,
+			UserInfo: userInfo{
+				NSLocalizedDescription: detail.Description,
+				Code:                   detail.Code,
+				IrisCode:               detail.Code,
+			},
+		})
+	}
+
+	return productErrors
+}
+
+func md5Checksum(file io.ReadSeeker) (string, error) {
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	hash := md5.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// transferStats formats the transfer statistics the same way as altool does,
+// e.g. "19555969 bytes in 1.831 seconds (10.7MB/s, 85.437Mbps)"
+func transferStats(size int64, duration time.Duration) string {
+	seconds := duration.Seconds()
+	if seconds <= 0 {
+		return fmt.Sprintf("%d bytes", size)
+	}
+	bytesPerSecond := float64(size) / seconds
+
+	return fmt.Sprintf("%d bytes in %.3f seconds (%.1fMB/s, %.3fMbps)", size, seconds, bytesPerSecond/1e6, bytesPerSecond*8/1e6)
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bitrise-io/go-utils/v2/log"
+	"github.com/stretchr/testify/require"
+)
+
+type staticTokenSource string
+
+func (s staticTokenSource) token() (string, error) {
+	return string(s), nil
+}
+
+// fakeAppStoreConnect is a local stand-in for the App Store Connect build upload endpoints
+type fakeAppStoreConnect struct {
+	t      *testing.T
+	server *httptest.Server
+
+	mu              sync.Mutex
+	uploaded        []byte
+	committedHash   string
+	stateQueries    int
+	finalState      string
+	stateErrors     []buildUploadStateDetail
+	createUploadErr string
+}
+
+func newFakeAppStoreConnect(t *testing.T, fileSize int64) *fakeAppStoreConnect {
+	f := &fakeAppStoreConnect{t: t, finalState: "COMPLETE", uploaded: make([]byte, fileSize)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/buildUploads", func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "Bearer test-token", r.Header.Get("Authorization"))
+		if f.createUploadErr != "" {
+			w.WriteHeader(http.StatusConflict)
+			_, _ = w.Write([]byte(f.createUploadErr))
+			return
+		}
+
+		var request resourceDocument
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&request))
+		var attributes buildUploadAttributes
+		require.NoError(t, json.Unmarshal(request.Data.Attributes, &attributes))
+		require.Equal(t, buildUploadAttributes{CFBundleShortVersionString: "1.0", CFBundleVersion: "42", Platform: "IOS"}, attributes)
+
+		writeJSON(t, w, http.StatusCreated, `{"data": {"type": "buildUploads", "id": "upload-1"}}`)
+	})
+	mux.HandleFunc("POST /v1/buildUploadFiles", func(w http.ResponseWriter, r *http.Request) {
+		half := fileSize / 2
+		writeJSON(t, w, http.StatusCreated, fmt.Sprintf(`{"data": {"type": "buildUploadFiles", "id": "file-1", "attributes": {"uploadOperations": [
+			{"method": "PUT", "url": "%[1]s/parts/0", "offset": 0, "length": %[2]d, "requestHeaders": [{"name": "Content-Type", "value": "application/octet-stream"}]},
+			{"method": "PUT", "url": "%[1]s/parts/1", "offset": %[2]d, "length": %[3]d, "requestHeaders": []}
+		]}}}`, f.server.URL, half, fileSize-half))
+	})
+	mux.HandleFunc("PUT /parts/{n}", func(w http.ResponseWriter, r *http.Request) {
+		require.Empty(t, r.Header.Get("Authorization"))
+		data, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+
+		offset := int64(0)
+		if r.PathValue("n") == "1" {
+			offset = fileSize / 2
+		}
+		f.mu.Lock()
+		copy(f.uploaded[offset:], data)
+		f.mu.Unlock()
+	})
+	mux.HandleFunc("PATCH /v1/buildUploadFiles/file-1", func(w http.ResponseWriter, r *http.Request) {
+		var request resourceDocument
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&request))
+		var attributes buildUploadFileAttributes
+		require.NoError(t, json.Unmarshal(request.Data.Attributes, &attributes))
+		require.True(t, attributes.Uploaded)
+
+		f.committedHash = attributes.SourceFileChecksums["file"].Hash
+		writeJSON(t, w, http.StatusOK, `{"data": {"type": "buildUploadFiles", "id": "file-1"}}`)
+	})
+	mux.HandleFunc("GET /v1/buildUploads/upload-1", func(w http.ResponseWriter, r *http.Request) {
+		f.stateQueries++
+		state := buildUploadState{State: buildUploadStateAwaitingUpload}
+		if f.stateQueries > 1 {
+			state = buildUploadState{State: f.finalState, Errors: f.stateErrors}
+		}
+		attributes, err := json.Marshal(buildUploadAttributes{State: &state})
+		require.NoError(t, err)
+
+		writeJSON(t, w, http.StatusOK, fmt.Sprintf(`{"data": {"type": "buildUploads", "id": "upload-1", "attributes": %s}}`, attributes))
+	})
+
+	f.server = httptest.NewServer(mux)
+	t.Cleanup(f.server.Close)
+
+	return f
+}
+
+func writeJSON(t *testing.T, w http.ResponseWriter, status int, body string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, err := w.Write([]byte(body))
+	require.NoError(t, err)
+}
+
+func newTestAppStoreConnectUploader(t *testing.T, fake *fakeAppStoreConnect, filePth string) appStoreConnectUploader {
+	return appStoreConnectUploader{
+		ctx:            context.Background(),
+		logger:         log.NewLogger(),
+		client:         newAppStoreConnectClient(fake.server.Client(), fake.server.URL, staticTokenSource("test-token")),
+		filePth:        filePth,
+		appID:          "1023456789",
+		packageDetails: packageDetails{bundleID: "com.example.app", bundleVersion: "42", bundleShortVersionString: "1.0"},
+		platform:       iOS,
+
+		statePollInterval: time.Millisecond,
+		statePollTimeout:  time.Second,
+	}
+}
+
+func writeTestPackage(t *testing.T) (string, []byte) {
+	content := make([]byte, 1001)
+	for i := range content {
+		content[i] = byte(i % 251)
+	}
+	pth := filepath.Join(t.TempDir(), "app.ipa")
+	require.NoError(t, os.WriteFile(pth, content, 0600))
+
+	return pth, content
+}
+
+func Test_appStoreConnectUploader_upload(t *testing.T) {
+	pth, content := writeTestPackage(t)
+	fake := newFakeAppStoreConnect(t, int64(len(content)))
+
+	stdOut, errorOut, result, err := newTestAppStoreConnectUploader(t, fake, pth).upload()
+
+	require.NoError(t, err)
+	require.Empty(t, errorOut)
+	require.Contains(t, stdOut, `"delivery-uuid": "upload-1"`)
+	require.Equal(t, content, fake.uploaded)
+	sum := md5.Sum(content)
+	require.Equal(t, hex.EncodeToString(sum[:]), fake.committedHash)
+	require.Equal(t, 2, fake.stateQueries)
+	require.Equal(t, "upload-1", result.SuccessDetails.DeliveryUUID)
+	require.Contains(t, result.SuccessDetails.Transferred, "1001 bytes")
+	require.NotEmpty(t, result.SuccessMessage)
+}
+
+func Test_appStoreConnectUploader_uploadFailedState(t *testing.T) {
+	pth, content := writeTestPackage(t)
+	fake := newFakeAppStoreConnect(t, int64(len(content)))
+	fake.finalState = buildUploadStateFailed
+	fake.stateErrors = []buildUploadStateDetail{{Code: "90062", Description: "Invalid bundle version."}}
+
+	_, _, result, err := newTestAppStoreConnectUploader(t, fake, pth).upload()
+
+	require.EqualError(t, err, "Invalid bundle version.  (code: 90062)")
+	require.Empty(t, result.SuccessMessage)
+	require.Equal(t, []productError{{
+		Message:  "Invalid bundle version.",
+		UserInfo: userInfo{NSLocalizedDescription: "Invalid bundle version.", Code: "90062", IrisCode: "90062"},
+	}}, result.ProductErrors)
+}
+
+func Test_appStoreConnectUploader_uploadAPIError(t *testing.T) {
+	pth, content := writeTestPackage(t)
+	fake := newFakeAppStoreConnect(t, int64(len(content)))
+	fake.createUploadErr = `{"errors": [{
+		"id": "a6a0f65a-22ee-4529-8249-e0df8bc254dc",
+		"status": "409",
+		"code": "ENTITY_ERROR.ATTRIBUTE.INVALID.DUPLICATE",
+		"title": "The provided entity includes an attribute with a value that has already been used",
+		"detail": "The bundle version must be higher than the previously uploaded version."
+	}]}`
+
+	_, errorOut, result, err := newTestAppStoreConnectUploader(t, fake, pth).upload()
+
+	wantErr := "failed to create build upload: The provided entity includes an attribute with a value that has already been used (409)  The bundle version must be higher than the previously uploaded version.  (code: ENTITY_ERROR.ATTRIBUTE.INVALID.DUPLICATE)"
+	require.EqualError(t, err, wantErr)
+	require.Equal(t, wantErr, errorOut)
+	require.Len(t, result.ProductErrors, 1)
+	require.Equal(t, 409, result.ProductErrors[0].Code)
+	require.Equal(t, "ENTITY_ERROR.ATTRIBUTE.INVALID.DUPLICATE", result.ProductErrors[0].UserInfo.IrisCode)
+}
+
+func Test_appStoreConnectUploader_uploadCancelled(t *testing.T) {
+	pth, content := writeTestPackage(t)
+	fake := newFakeAppStoreConnect(t, int64(len(content)))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	uploader := newTestAppStoreConnectUploader(t, fake, pth)
+	uploader.ctx = ctx
+
+	_, _, _, err := uploader.upload()
+
+	require.ErrorIs(t, err, context.Canceled)
+	require.Zero(t, fake.stateQueries)
+}
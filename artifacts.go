@@ -0,0 +1,445 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/avast/retry-go/v4"
+	"github.com/bitrise-io/go-utils/v2/errorutil"
+	"github.com/bitrise-io/go-utils/v2/log"
+	"github.com/bitrise-io/go-xcode/v2/metaparser"
+)
+
+const (
+	failurePolicyFailFast = "fail_fast"
+	failurePolicyContinue = "continue"
+)
+
+// Modes of the preflight check Inputs, any other value (off) disables the check
+const (
+	checkModeFail = "fail"
+	checkModeWarn = "warn"
+)
+
+// runCheck runs the preflight check of the artifact in the given mode,
+// in warn mode a failed check is logged and the upload continues
+func runCheck(logger log.Logger, mode string, check func() error) error {
+	if mode != checkModeFail && mode != checkModeWarn {
+		return nil
+	}
+
+	err := check()
+	if err == nil || mode == checkModeFail {
+		return err
+	}
+	logger.Warnf("%s", err)
+	logger.Warnf("Uploading anyway, the check is in %s mode", checkModeWarn)
+
+	return nil
+}
+
+// expandArtifactPaths returns the artifacts listed in the IPA and PKG path Inputs,
+// both accept pipe (|) or newline separated lists (like BITRISE_IPA_PATH_LIST) and glob patterns.
+func expandArtifactPaths(ipaPaths, pkgPaths string) ([]string, error) {
+	var artifacts []string
+	seen := map[string]bool{}
+	for _, pattern := range append(parseList(ipaPaths), parseList(pkgPaths)...) {
+		matches := []string{pattern}
+		if strings.ContainsAny(pattern, "*?[") {
+			var err error
+			if matches, err = filepath.Glob(pattern); err != nil {
+				return nil, fmt.Errorf("invalid artifact path pattern (%s): %w", pattern, err)
+			}
+			if len(matches) == 0 {
+				return nil, fmt.Errorf("no artifact matches the pattern: %s", pattern)
+			}
+		}
+
+		for _, match := range matches {
+			if seen[match] {
+				continue
+			}
+			seen[match] = true
+			artifacts = append(artifacts, match)
+		}
+	}
+
+	if len(artifacts) == 0 {
+		return nil, fmt.Errorf("at least one artifact is required, provide ipa_path or pkg_path")
+	}
+
+	return artifacts, nil
+}
+
+// artifactResult is the outcome of deploying a single artifact
+type artifactResult struct {
+	path            string
+	betaReviewState string
+	skipped         bool
+	err             error
+}
+
+// uploaderFactory creates the uploader of a single artifact, with validate the uploader only validates the artifact
+type uploaderFactory func(logger log.Logger, filePth string, packageDetails packageDetails, appID string, platform platformType, validate bool) (uploader, error)
+
+// artifactDeployer uploads an artifact and runs the requested post-processing actions on the uploaded build
+type artifactDeployer struct {
+	logger            log.Logger
+	newLogger         func(prefix string) log.Logger
+	newUploader       uploaderFactory
+	cfg               Config
+	retryPolicy       retryPolicy
+	parser            *metaparser.Parser
+	auth              *authChain
+	appIDCacheDir     string
+	useAPI            bool
+	waitForProcessing bool
+	betaGroups        []string
+	whatsNew          map[string]string
+	releaseToAppStore bool
+	releaseSettings   appStoreReleaseSettings
+}
+
+// deployAll deploys the artifacts with at most concurrency uploads running in parallel,
+// with the fail_fast policy the artifacts not yet started when an artifact fails are skipped.
+// The artifacts not yet started when the context is cancelled fail.
+func (d artifactDeployer) deployAll(ctx context.Context, artifacts []string, failurePolicy string, concurrency int) []artifactResult {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	results := make([]artifactResult, len(artifacts))
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		failed bool
+	)
+	slots := make(chan struct{}, concurrency)
+	for i, filePth := range artifacts {
+		slots <- struct{}{}
+
+		// A cancelled step (SIGINT or SIGTERM) does not start the remaining artifacts
+		if err := ctx.Err(); err != nil {
+			results[i] = artifactResult{path: filePth, err: fmt.Errorf("Deploying %s not started: %w", filepath.Base(filePth), err)}
+			<-slots
+			continue
+		}
+
+		mu.Lock()
+		skip := failed && failurePolicy == failurePolicyFailFast
+		mu.Unlock()
+		if skip {
+			results[i] = artifactResult{path: filePth, skipped: true}
+			<-slots
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			logger := d.logger
+			if len(artifacts) > 1 {
+				// Each artifact logs with its own prefix, as the logs of parallel uploads are interleaved
+				logger = d.newLogger(fmt.Sprintf("[%d/%d %s] ", i+1, len(artifacts), filepath.Base(filePth)))
+				logger.Infof("Deploying %s", filePth)
+			}
+
+			state, err := d.deploy(ctx, logger, filePth)
+			if err != nil {
+				logger.Errorf("%s", errorutil.FormattedError(err))
+				mu.Lock()
+				failed = true
+				mu.Unlock()
+			}
+			results[i] = artifactResult{path: filePth, betaReviewState: state, err: err}
+		}()
+	}
+	wg.Wait()
+
+	return results
+}
+
+// completePackageDetails reads the App details not provided by the Inputs from the package
+func completePackageDetails(parser *metaparser.Parser, filePth string, details packageDetails) (packageDetails, error) {
+	// Every Input overrides the respective Info.plist value parsed from the IPA
+	if details.hasMissingFields() {
+		var err error
+		if details, err = readPackageDetails(parser, filePth, details); err != nil {
+			return details, fmt.Errorf("could not read App details from Info.plist: %w", err)
+		}
+	}
+	if details.hasMissingFields() {
+		return details, fmt.Errorf("could not read all App details from Info.plist: %+v", details)
+	}
+
+	return details, nil
+}
+
+// currentAPIClient returns the App Store Connect API client of the credentials in use, nil if they are not an API key
+func (d artifactDeployer) currentAPIClient() *appStoreConnectClient {
+	if d.auth == nil {
+		return nil
+	}
+	_, candidate := d.auth.get()
+
+	return candidate.apiClient
+}
+
+// lookUpAppID looks up the app ID with the API key in use, if it is rejected the lookup falls back
+// to the next credentials of the chain, like the upload does
+func (d artifactDeployer) lookUpAppID(ctx context.Context, logger log.Logger, bundleID string) (string, error) {
+	if d.auth == nil {
+		return "", errors.New("looking up the app ID requires API key authentication")
+	}
+
+	index, candidate := d.auth.get()
+	for {
+		if candidate.apiClient == nil {
+			return "", fmt.Errorf("looking up the app ID requires API key authentication, the credentials in use are: %s", candidate.source)
+		}
+
+		var cache *appIDCache
+		if d.appIDCacheDir != "" {
+			// Bundle IDs are unique within a team only, the cache is per team of the key
+			cache = newAppIDCache(d.appIDCacheDir, apiKeyTeam(candidate.credentials.APIKey.IssuerID, candidate.credentials.APIKey.KeyID))
+		}
+		appID, err := lookUpAppID(ctx, logger, candidate.apiClient, cache, bundleID)
+		if err == nil || !isAuthenticationError(err, altoolResult{}, "") {
+			return appID, err
+		}
+
+		rejected := candidate.source
+		var ok bool
+		if index, candidate, ok = d.auth.fallback(index); !ok {
+			return "", err
+		}
+		logger.Warnf("Authentication failed with: %s, falling back to: %s", rejected, candidate.source)
+	}
+}
+
+// deploy uploads the artifact and returns the TestFlight beta review state, if the build was submitted for beta review
+func (d artifactDeployer) deploy(ctx context.Context, logger log.Logger, filePth string) (string, error) {
+	cfg := d.cfg
+
+	if filepath.Ext(filePth) == ".pkg" {
+		if err := runCheck(logger, cfg.VerifyPKGSignature, func() error { return preflightPKGSignature(logger, filePth) }); err != nil {
+			return "", err
+		}
+	}
+	if err := runCheck(logger, cfg.VerifyProvisioningProfile, func() error { return preflightProvisioningProfile(logger, d.parser, filePth) }); err != nil {
+		return "", err
+	}
+	if err := runCheck(logger, cfg.EntitlementsLint, func() error { return preflightEntitlements(logger, filePth) }); err != nil {
+		return "", err
+	}
+
+	packageDetails := packageDetails{
+		bundleID:                 cfg.BundleID,
+		bundleVersion:            cfg.BundleVersion,
+		bundleShortVersionString: cfg.BundleShortVersionString,
+	}
+	// With API key authentication the app ID is looked up by the bundle ID, so altool uploads with --upload-package
+	lookUpApp := cfg.AppID == "" && d.currentAPIClient() != nil
+	// If App ID is provided, BundleID, Version and ShortVersion must be provided too, or read from the package
+	requireDetails := cfg.AppID != "" || d.useAPI || d.waitForProcessing
+	if requireDetails || lookUpApp {
+		var err error
+		if packageDetails, err = completePackageDetails(d.parser, filePth, packageDetails); err != nil {
+			if requireDetails {
+				logger.Infof("Provide App details Inputs to skip Info.plist parsing: app_id, bundle_id, bundle_version, bundle_short_version_string.")
+				return "", err
+			}
+			logger.Warnf("Skipping the app ID lookup, %s", err)
+			logger.Warnf("Provide the app_id Input, or the bundle_id, bundle_version and bundle_short_version_string Inputs to look it up.")
+			lookUpApp = false
+		}
+	}
+
+	var platform platformType
+	if d.useAPI || d.waitForProcessing {
+		platform = getPlatformType(logger, filePth, cfg.Platform)
+	}
+
+	appID := cfg.AppID
+	if lookUpApp {
+		var err error
+		if appID, err = d.lookUpAppID(ctx, logger, packageDetails.bundleID); err != nil {
+			var notFoundErr appNotFoundError
+			if requireDetails || errors.As(err, &notFoundErr) {
+				return "", err
+			}
+			// altool uploads without the app ID too, a failed lookup does not break the upload
+			logger.Warnf("Uploading without the app ID: %s", err)
+		}
+	}
+
+	if cfg.Mode == modeValidate || cfg.Mode == modeValidateThenUpload {
+		validator, err := d.newUploader(logger, filePth, packageDetails, appID, platform, true)
+		if err != nil {
+			return "", err
+		}
+
+		_, result, validateErr := uploadWithRetry(logger, validator, d.retryPolicy, retry.Context(ctx))
+		printUploadWarnings(logger, result)
+		if validateErr != nil {
+			return "", fmt.Errorf("Validating %s failed: %w", filepath.Base(filePth), validateErr)
+		}
+		logger.Donef("%s validated", filepath.Base(filePth))
+
+		if cfg.Mode == modeValidate {
+			return "", nil
+		}
+	}
+
+	packageUploader, err := d.newUploader(logger, filePth, packageDetails, appID, platform, false)
+	if err != nil {
+		return "", err
+	}
+
+	_, result, uploadErr := uploadWithRetry(logger, packageUploader, d.retryPolicy, retry.Context(ctx))
+	printUploadWarnings(logger, result)
+	if uploadErr != nil {
+		return "", fmt.Errorf("Uploading %s failed: %w", filepath.Base(filePth), uploadErr)
+	}
+	if result.SuccessMessage != "" {
+		logger.Infof("%s", result.SuccessMessage)
+	}
+	logger.Donef("%s uploaded", filepath.Base(filePth))
+
+	if !d.waitForProcessing {
+		return "", nil
+	}
+
+	return d.distribute(ctx, logger, appID, packageDetails, platform)
+}
+
+// distribute waits for the uploaded build to be processed, then configures TestFlight and the App Store version
+func (d artifactDeployer) distribute(ctx context.Context, logger log.Logger, appID string, packageDetails packageDetails, platform platformType) (string, error) {
+	cfg := d.cfg
+
+	// The upload may have fallen back to other credentials, the build is configured with the ones in use
+	client := d.currentAPIClient()
+	if client == nil {
+		return "", errors.New("Build processing and TestFlight configuration require API key authentication, the upload fell back to Apple ID credentials")
+	}
+
+	if appID == "" {
+		var err error
+		if appID, err = d.lookUpAppID(ctx, logger, packageDetails.bundleID); err != nil {
+			return "", fmt.Errorf("failed to find the uploaded app: %w", err)
+		}
+	}
+
+	logger.Println()
+	waiter := newBuildProcessingWaiter(logger, client, time.Duration(cfg.ProcessingPollInterval)*time.Second, time.Duration(cfg.ProcessingTimeout)*time.Minute)
+	processedBuild, err := waiter.waitForProcessing(ctx, appID, packageDetails, platform)
+	if err != nil {
+		return "", fmt.Errorf("Build processing failed: %w", err)
+	}
+	logger.Donef("Build processed")
+
+	if len(d.whatsNew) > 0 {
+		logger.Println()
+		logger.Infof("Setting TestFlight What to Test notes")
+		if err := updateBetaBuildLocalizations(ctx, logger, client, processedBuild.id, d.whatsNew); err != nil {
+			return "", err
+		}
+	}
+
+	if len(d.betaGroups) > 0 {
+		logger.Println()
+		logger.Infof("Adding build to TestFlight beta groups")
+
+		var failedGroups []string
+		for _, result := range distributeToBetaGroups(ctx, logger, client, appID, processedBuild, d.betaGroups) {
+			if result.err != nil {
+				logger.Errorf("- %s: %s", result.group, result.err)
+				failedGroups = append(failedGroups, result.group)
+			} else {
+				logger.Donef("- %s: added", result.group)
+			}
+		}
+		if len(failedGroups) > 0 {
+			return "", fmt.Errorf("Failed to add the build to beta groups: %s", strings.Join(failedGroups, ", "))
+		}
+	}
+
+	var betaReviewState string
+	if cfg.SubmitForBetaReview {
+		logger.Println()
+		logger.Infof("Submitting build for TestFlight beta review")
+
+		if details := cfg.betaReviewDetails(); !details.isEmpty() {
+			if err := updateBetaReviewDetails(ctx, client, appID, details); err != nil {
+				return "", fmt.Errorf("Beta review submission failed: %w", err)
+			}
+		}
+		if betaReviewState, err = submitForBetaReview(ctx, logger, client, processedBuild.id); err != nil {
+			return "", fmt.Errorf("Beta review submission failed: %w", err)
+		}
+		logger.Donef("Build submitted for beta review, state: %s", betaReviewState)
+	}
+
+	if d.releaseToAppStore {
+		logger.Println()
+		logger.Infof("Preparing App Store version %s", packageDetails.bundleShortVersionString)
+
+		releaser := newAppStoreReleaser(logger, client, appID, platform)
+		version, err := releaser.prepareVersion(ctx, packageDetails.bundleShortVersionString, processedBuild.id, d.releaseSettings)
+		if err != nil {
+			return betaReviewState, fmt.Errorf("Preparing App Store version failed: %w", err)
+		}
+		logger.Donef("App Store version %s prepared", packageDetails.bundleShortVersionString)
+
+		if cfg.SubmitForAppReview {
+			if err := releaser.submitForReview(ctx, version); err != nil {
+				return betaReviewState, fmt.Errorf("App Store review submission failed: %w", err)
+			}
+			logger.Donef("App Store version %s submitted for review", packageDetails.bundleShortVersionString)
+		}
+	}
+
+	return betaReviewState, nil
+}
+
+// printUploadWarnings logs the warnings of an upload or validation, the error output is streamed while altool runs
+func printUploadWarnings(logger log.Logger, result altoolResult) {
+	warnings := result.getWarnings()
+	if len(warnings) == 0 {
+		return
+	}
+
+	logger.Println()
+	for _, warning := range warnings {
+		logger.Warnf("%s", warning)
+	}
+	logger.Println()
+}
+
+// printSummary logs the outcome of every artifact and returns the number of failed artifacts
+func printSummary(logger log.Logger, results []artifactResult) int {
+	logger.Println()
+	logger.Infof("Summary")
+
+	failed := 0
+	for _, result := range results {
+		switch {
+		case result.skipped:
+			logger.Warnf("- %s: skipped", result.path)
+		case result.err != nil:
+			failed++
+			logger.Errorf("- %s: failed: %s", result.path, result.err)
+		default:
+			logger.Donef("- %s: deployed", result.path)
+		}
+	}
+
+	return failed
+}
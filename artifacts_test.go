@@ -0,0 +1,335 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bitrise-io/go-utils/v2/log"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_expandArtifactPaths(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"ios.ipa", "tvos.ipa", "macos.pkg"} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte{}, 0600))
+	}
+	iosPth := filepath.Join(dir, "ios.ipa")
+	tvosPth := filepath.Join(dir, "tvos.ipa")
+	macosPth := filepath.Join(dir, "macos.pkg")
+
+	tests := []struct {
+		name     string
+		ipaPaths string
+		pkgPaths string
+		want     []string
+		wantErr  string
+	}{
+		{name: "Single IPA", ipaPaths: iosPth, want: []string{iosPth}},
+		{name: "Single PKG", pkgPaths: " " + macosPth + " ", want: []string{macosPth}},
+		{name: "IPA path list", ipaPaths: iosPth + "|" + tvosPth, want: []string{iosPth, tvosPth}},
+		{name: "IPA and PKG", ipaPaths: iosPth + "\n" + tvosPth, pkgPaths: macosPth, want: []string{iosPth, tvosPth, macosPth}},
+		{name: "Glob pattern", ipaPaths: filepath.Join(dir, "*.ipa"), want: []string{iosPth, tvosPth}},
+		{name: "Duplicates are dropped", ipaPaths: iosPth + "|" + filepath.Join(dir, "*.ipa"), want: []string{iosPth, tvosPth}},
+		{name: "Pattern without match", ipaPaths: filepath.Join(dir, "*.xcarchive"), wantErr: "no artifact matches the pattern"},
+		{name: "No artifact", ipaPaths: " | ", wantErr: "at least one artifact is required"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := expandArtifactPaths(tt.ipaPaths, tt.pkgPaths)
+			if tt.wantErr != "" {
+				require.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestConfig_validateArtifacts(t *testing.T) {
+	require.NoError(t, Config{AppID: "1023456789"}.validateArtifacts([]string{"app.ipa"}))
+	require.NoError(t, Config{}.validateArtifacts([]string{"ios.ipa", "macos.pkg"}))
+	require.Error(t, Config{BundleID: "io.bitrise.app"}.validateArtifacts([]string{"ios.ipa", "macos.pkg"}))
+}
+
+func Test_artifactDeployer_deployAll(t *testing.T) {
+	artifacts := []string{"a.ipa", "b.ipa", "c.ipa", "d.ipa", "e.ipa"}
+	failing := map[string]bool{"b.ipa": true, "d.ipa": true}
+
+	tests := []struct {
+		name          string
+		failurePolicy string
+		concurrency   int
+		wantDeployed  []string
+		wantFailed    []string
+		wantSkipped   []string
+	}{
+		{
+			name:          "Continue on failure",
+			failurePolicy: failurePolicyContinue,
+			concurrency:   2,
+			wantDeployed:  []string{"a.ipa", "c.ipa", "e.ipa"},
+			wantFailed:    []string{"b.ipa", "d.ipa"},
+		},
+		{
+			name:          "Fail fast",
+			failurePolicy: failurePolicyFailFast,
+			concurrency:   1,
+			wantDeployed:  []string{"a.ipa"},
+			wantFailed:    []string{"b.ipa"},
+			wantSkipped:   []string{"c.ipa", "d.ipa", "e.ipa"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				mu            sync.Mutex
+				running       int
+				maxRunning    int
+				uploadedPaths []string
+			)
+			deployer := artifactDeployer{
+				logger:    log.NewLogger(),
+				newLogger: func(prefix string) log.Logger { return log.NewLogger(log.WithPrefix(prefix)) },
+				newUploader: func(logger log.Logger, filePth string, packageDetails packageDetails, appID string, platform platformType, validate bool) (uploader, error) {
+					uploader := newMockUploader(t)
+					call := uploader.On("upload")
+					if failing[filePth] {
+						call.Return("", "unknown-error", altoolResult{}, errors.New("test-error"))
+					} else {
+						call.Return("", "", altoolResult{}, nil)
+					}
+					call.Run(func(mock.Arguments) {
+						mu.Lock()
+						running++
+						maxRunning = max(maxRunning, running)
+						uploadedPaths = append(uploadedPaths, filePth)
+						mu.Unlock()
+
+						time.Sleep(50 * time.Millisecond)
+
+						mu.Lock()
+						running--
+						mu.Unlock()
+					}).Once()
+					return uploader, nil
+				},
+			}
+
+			results := deployer.deployAll(context.Background(), artifacts, tt.failurePolicy, tt.concurrency)
+
+			var deployed, failed, skipped []string
+			for i, result := range results {
+				require.Equal(t, artifacts[i], result.path)
+				switch {
+				case result.skipped:
+					skipped = append(skipped, result.path)
+				case result.err != nil:
+					require.EqualError(t, result.err, "Uploading "+result.path+" failed: test-error")
+					failed = append(failed, result.path)
+				default:
+					deployed = append(deployed, result.path)
+				}
+			}
+			require.Equal(t, tt.wantDeployed, deployed)
+			require.Equal(t, tt.wantFailed, failed)
+			require.Equal(t, tt.wantSkipped, skipped)
+			require.Equal(t, tt.concurrency, maxRunning)
+			require.Len(t, uploadedPaths, len(tt.wantDeployed)+len(tt.wantFailed))
+			require.Equal(t, len(tt.wantFailed), printSummary(log.NewLogger(), results))
+		})
+	}
+}
+
+func Test_artifactDeployer_deployModes(t *testing.T) {
+	tests := []struct {
+		name          string
+		mode          string
+		validationErr error
+		wantCalls     []bool
+		wantErr       string
+	}{
+		{name: "Upload", mode: modeUpload, wantCalls: []bool{false}},
+		{name: "Validate", mode: modeValidate, wantCalls: []bool{true}},
+		{name: "Validate then upload", mode: modeValidateThenUpload, wantCalls: []bool{true, false}},
+		{
+			name:          "Failed validation skips upload",
+			mode:          modeValidateThenUpload,
+			validationErr: errors.New("Validation failed (409) Invalid Bundle."),
+			wantCalls:     []bool{true},
+			wantErr:       "Validating app.ipa failed: Validation failed (409) Invalid Bundle.",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls []bool
+			deployer := artifactDeployer{
+				logger: log.NewLogger(),
+				cfg:    Config{Mode: tt.mode},
+				newUploader: func(logger log.Logger, filePth string, packageDetails packageDetails, appID string, platform platformType, validate bool) (uploader, error) {
+					calls = append(calls, validate)
+					uploader := newMockUploader(t)
+					if validate {
+						uploader.On("upload").Return("", "", altoolResult{}, tt.validationErr).Once()
+					} else {
+						uploader.On("upload").Return("", "", altoolResult{SuccessMessage: "No errors uploading"}, nil).Once()
+					}
+					return uploader, nil
+				},
+			}
+
+			_, err := deployer.deploy(context.Background(), log.NewLogger(), "app.ipa")
+
+			require.Equal(t, tt.wantCalls, calls)
+			if tt.wantErr != "" {
+				require.EqualError(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
+
+func Test_artifactDeployer_deploy_looksUpAppID(t *testing.T) {
+	tests := []struct {
+		name      string
+		bundleID  string
+		wantAppID string
+		wantErr   string
+	}{
+		{name: "App found", bundleID: "com.example.app", wantAppID: "1023456789"},
+		{name: "App not found", bundleID: "com.example.missing", wantErr: "no app found in App Store Connect with bundle ID com.example.missing"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var lookups int
+			var uploadedAppID *string
+			deployer := artifactDeployer{
+				logger: log.NewLogger(),
+				cfg:    Config{Mode: modeUpload, BundleID: tt.bundleID, BundleVersion: "42", BundleShortVersionString: "1.0"},
+				auth:   newAuthChain([]authCandidate{{source: "inputs", apiClient: newFakeAppsAPI(t, &lookups)}}),
+				newUploader: func(logger log.Logger, filePth string, packageDetails packageDetails, appID string, platform platformType, validate bool) (uploader, error) {
+					uploadedAppID = &appID
+					uploader := newMockUploader(t)
+					uploader.On("upload").Return("", "", altoolResult{}, nil).Once()
+					return uploader, nil
+				},
+			}
+
+			_, err := deployer.deploy(context.Background(), log.NewLogger(), "macos.pkg")
+
+			if tt.wantErr != "" {
+				require.ErrorContains(t, err, tt.wantErr)
+				require.Nil(t, uploadedAppID, "nothing should be uploaded")
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.wantAppID, *uploadedAppID)
+		})
+	}
+}
+
+func Test_artifactDeployer_lookUpAppID_fallback(t *testing.T) {
+	rejecting := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(t, w, http.StatusUnauthorized, `{"errors": [{"status": "401", "code": "NOT_AUTHORIZED", "title": "Authentication credentials are missing or invalid."}]}`)
+	}))
+	defer rejecting.Close()
+	rejectedClient := newAppStoreConnectClient(rejecting.Client(), rejecting.URL, staticTokenSource("test-token"))
+	var lookups int
+
+	tests := []struct {
+		name        string
+		candidates  []authCandidate
+		wantAppID   string
+		wantErr     string
+		wantCurrent int
+	}{
+		{
+			name:        "Falls back to the next API key",
+			candidates:  []authCandidate{{source: "connection", apiClient: rejectedClient}, {source: "inputs", apiClient: newFakeAppsAPI(t, &lookups)}},
+			wantAppID:   "1023456789",
+			wantCurrent: 1,
+		},
+		{
+			name:        "Falls back to Apple ID",
+			candidates:  []authCandidate{{source: "connection", apiClient: rejectedClient}, {source: "inputs"}},
+			wantErr:     "looking up the app ID requires API key authentication, the credentials in use are: inputs",
+			wantCurrent: 1,
+		},
+		{
+			name:       "Every key rejected",
+			candidates: []authCandidate{{source: "connection", apiClient: rejectedClient}},
+			wantErr:    "Authentication credentials are missing or invalid.",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deployer := artifactDeployer{auth: newAuthChain(tt.candidates)}
+
+			appID, err := deployer.lookUpAppID(context.Background(), log.NewLogger(), "com.example.app")
+
+			if tt.wantErr != "" {
+				require.ErrorContains(t, err, tt.wantErr)
+			} else {
+				require.NoError(t, err)
+				require.Equal(t, tt.wantAppID, appID)
+			}
+			current, _ := deployer.auth.get()
+			require.Equal(t, tt.wantCurrent, current)
+		})
+	}
+}
+
+func Test_artifactDeployer_deployAllCancelled(t *testing.T) {
+	deployer := artifactDeployer{
+		logger:    log.NewLogger(),
+		newLogger: func(prefix string) log.Logger { return log.NewLogger(log.WithPrefix(prefix)) },
+		newUploader: func(logger log.Logger, filePth string, packageDetails packageDetails, appID string, platform platformType, validate bool) (uploader, error) {
+			return newMockUploader(t), nil
+		},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	results := deployer.deployAll(ctx, []string{"a.ipa", "b.ipa"}, failurePolicyContinue, 1)
+
+	for _, result := range results {
+		require.ErrorIs(t, result.err, context.Canceled)
+	}
+	require.Equal(t, 2, printSummary(log.NewLogger(), results))
+}
+
+func Test_runCheck(t *testing.T) {
+	checkErr := errors.New("Installer signature verification failed")
+	tests := []struct {
+		name      string
+		mode      string
+		wantCheck bool
+		wantErr   error
+	}{
+		{name: "Fail", mode: checkModeFail, wantCheck: true, wantErr: checkErr},
+		{name: "Warn", mode: checkModeWarn, wantCheck: true},
+		{name: "Off", mode: "off"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checked := false
+
+			err := runCheck(log.NewLogger(), tt.mode, func() error {
+				checked = true
+				return checkErr
+			})
+
+			require.Equal(t, tt.wantErr, err)
+			require.Equal(t, tt.wantCheck, checked)
+		})
+	}
+}
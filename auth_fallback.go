@@ -0,0 +1,183 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"regexp"
+	"strconv"
+	"sync"
+
+	"github.com/bitrise-io/go-utils/v2/log"
+	"github.com/bitrise-io/go-xcode/appleauth"
+	"github.com/bitrise-io/go-xcode/devportalservice"
+)
+
+// unableToAuthenticateErrorCode is the altool error code of rejected credentials
+const unableToAuthenticateErrorCode = -19209
+
+var unableToAuthenticatePattern = regexp.MustCompile(`(?s)Unable to authenticate.*-19209`)
+
+// authCandidate is the credentials provided by a source of the connection chain
+type authCandidate struct {
+	source      string
+	credentials appleauth.Credentials
+	// apiKeyPath is the private key written for altool, if the credentials are an API key
+	apiKeyPath string
+	// apiClient is the App Store Connect API client using the credentials, if they are an API key
+	apiClient *appStoreConnectClient
+}
+
+// collectCredentials returns the credentials of every source in order, unlike appleauth.Select which returns the first
+// one only. A source failing after credentials were found is skipped, so a fallback source does not break the first.
+func collectCredentials(logger log.Logger, conn *devportalservice.AppleDeveloperConnection, sources []appleauth.Source, inputs appleauth.Inputs) ([]authCandidate, error) {
+	var candidates []authCandidate
+	for _, source := range sources {
+		credentials, err := source.Fetch(conn, inputs)
+		if err != nil {
+			if len(candidates) == 0 {
+				return nil, err
+			}
+			logger.Warnf("Skipping fallback credentials (%s): %s", authSourceName(source), err)
+			continue
+		}
+		if credentials != nil {
+			candidates = append(candidates, authCandidate{source: authSourceName(source), credentials: *credentials})
+		}
+	}
+
+	if len(candidates) == 0 {
+		return nil, &appleauth.MissingAuthConfigError{}
+	}
+
+	return candidates, nil
+}
+
+func authSourceName(source appleauth.Source) string {
+	switch source.(type) {
+	case *appleauth.ConnectionAPIKeySource:
+		return "Bitrise Apple Developer Connection, API key"
+	case *appleauth.ConnectionAppleIDSource:
+		return "Bitrise Apple Developer Connection, Apple ID"
+	case *appleauth.InputAPIKeySource:
+		return "Step Inputs, API key"
+	case *appleauth.InputAppleIDSource:
+		return "Step Inputs, Apple ID"
+	default:
+		return source.Description()
+	}
+}
+
+// authChain holds the credential candidates and the one in use, it is shared by the artifacts,
+// so once a source is rejected the remaining artifacts start with the next one.
+type authChain struct {
+	mu         sync.Mutex
+	candidates []authCandidate
+	current    int
+}
+
+func newAuthChain(candidates []authCandidate) *authChain {
+	return &authChain{candidates: candidates}
+}
+
+func (c *authChain) get() (int, authCandidate) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.current, c.candidates[c.current]
+}
+
+// fallback moves on from the rejected candidate, it returns false if there is no next candidate
+func (c *authChain) fallback(rejected int) (int, authCandidate, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.current == rejected {
+		if rejected+1 >= len(c.candidates) {
+			return c.current, c.candidates[c.current], false
+		}
+		c.current++
+	}
+
+	return c.current, c.candidates[c.current], true
+}
+
+// fallbackUploader uploads with the current credentials of the chain,
+// and retries the upload right away with the next source's credentials if authentication fails.
+type fallbackUploader struct {
+	logger      log.Logger
+	chain       *authChain
+	newUploader func(candidate authCandidate) (uploader, error)
+}
+
+func newFallbackUploader(logger log.Logger, chain *authChain, newUploader func(candidate authCandidate) (uploader, error)) uploader {
+	return fallbackUploader{logger: logger, chain: chain, newUploader: newUploader}
+}
+
+func (u fallbackUploader) upload() (string, string, altoolResult, error) {
+	index, candidate := u.chain.get()
+	for {
+		u.logger.Printf("Authenticating with: %s", candidate.source)
+		candidateUploader, err := u.newUploader(candidate)
+		if err != nil {
+			return "", "", altoolResult{}, err
+		}
+
+		stdOut, errorOut, result, err := candidateUploader.upload()
+		if err == nil {
+			u.logger.Donef("Authenticated with: %s", candidate.source)
+			return stdOut, errorOut, result, nil
+		}
+		if !isAuthenticationError(err, result, errorOut) {
+			return stdOut, errorOut, result, err
+		}
+
+		rejected := candidate.source
+		var ok bool
+		if index, candidate, ok = u.chain.fallback(index); !ok {
+			u.logger.Warnf("Authentication failed with: %s, no more credentials to fall back to", rejected)
+			return stdOut, errorOut, result, credentialsRejectedError{err: err}
+		}
+		u.logger.Warnf("Authentication failed with: %s, falling back to: %s", rejected, candidate.source)
+	}
+}
+
+// credentialsRejectedError is the authentication error of the last credentials of the chain. Unlike a single
+// rejection (which altool reports for transient failures too) it is not retried, every credential was tried already.
+type credentialsRejectedError struct {
+	err error
+}
+
+func (e credentialsRejectedError) Error() string {
+	return e.err.Error()
+}
+
+func (e credentialsRejectedError) Unwrap() error {
+	return e.err
+}
+
+// isAuthenticationError tells whether the credentials were rejected, by altool error code -19209 or HTTP status 401
+func isAuthenticationError(err error, result altoolResult, errorOut string) bool {
+	var apiErr apiError
+	if errors.As(err, &apiErr) && apiErr.statusCode == http.StatusUnauthorized {
+		return true
+	}
+
+	for _, productErr := range flattenProductErrors(result.ProductErrors) {
+		if productErr.Code == unableToAuthenticateErrorCode {
+			return true
+		}
+		for _, info := range []userInfo{productErr.UserInfo, productErr.LegacyUserInfo} {
+			if info.Status == strconv.Itoa(http.StatusUnauthorized) {
+				return true
+			}
+			for _, match := range embeddedErrorCodePattern.FindAllStringSubmatch(info.NSUnderlyingError, -1) {
+				if match[1] == strconv.Itoa(unableToAuthenticateErrorCode) {
+					return true
+				}
+			}
+		}
+	}
+
+	// Output of altool versions without JSON output
+	return unableToAuthenticatePattern.MatchString(errorOut)
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bitrise-io/go-utils/v2/log"
+	"github.com/bitrise-io/go-xcode/appleauth"
+	"github.com/bitrise-io/go-xcode/devportalservice"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeAuthSource struct {
+	description string
+	credentials *appleauth.Credentials
+	err         error
+}
+
+func (s fakeAuthSource) Fetch(*devportalservice.AppleDeveloperConnection, appleauth.Inputs) (*appleauth.Credentials, error) {
+	return s.credentials, s.err
+}
+
+func (s fakeAuthSource) Description() string {
+	return s.description
+}
+
+func Test_collectCredentials(t *testing.T) {
+	apiKey := &appleauth.Credentials{APIKey: &devportalservice.APIKeyConnection{KeyID: "ABCDE12345"}}
+	appleID := &appleauth.Credentials{AppleID: &appleauth.AppleID{Username: "user@example.com"}}
+
+	tests := []struct {
+		name    string
+		sources []appleauth.Source
+		want    []authCandidate
+		wantErr string
+	}{
+		{
+			name:    "Every source",
+			sources: []appleauth.Source{fakeAuthSource{"connection", apiKey, nil}, fakeAuthSource{"empty", nil, nil}, fakeAuthSource{"inputs", appleID, nil}},
+			want:    []authCandidate{{source: "connection", credentials: *apiKey}, {source: "inputs", credentials: *appleID}},
+		},
+		{
+			name:    "Failing fallback source is skipped",
+			sources: []appleauth.Source{fakeAuthSource{"connection", apiKey, nil}, fakeAuthSource{"inputs", nil, errors.New("could not fetch private key")}},
+			want:    []authCandidate{{source: "connection", credentials: *apiKey}},
+		},
+		{
+			name:    "Failing first source",
+			sources: []appleauth.Source{fakeAuthSource{"inputs", nil, errors.New("could not fetch private key")}, fakeAuthSource{"connection", apiKey, nil}},
+			wantErr: "could not fetch private key",
+		},
+		{
+			name:    "No credentials",
+			sources: []appleauth.Source{fakeAuthSource{"empty", nil, nil}},
+			wantErr: "no credentials provided",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := collectCredentials(log.NewLogger(), nil, tt.sources, appleauth.Inputs{})
+			if tt.wantErr != "" {
+				require.EqualError(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_isAuthenticationError(t *testing.T) {
+	stdOut, err := os.ReadFile(filepath.Join("testdata", "altool", "unable_to_authenticate.json"))
+	require.NoError(t, err)
+	unableToAuthenticateResult, parseErr := parseAltoolOutput(log.NewLogger(), string(stdOut), "", true)
+	require.Error(t, parseErr)
+
+	require.True(t, isAuthenticationError(parseErr, unableToAuthenticateResult, ""))
+	require.True(t, isAuthenticationError(errors.New("test-error"), altoolResult{}, unableToAuthenticate))
+	require.True(t, isAuthenticationError(apiError{statusCode: 401}, altoolResult{}, ""))
+	require.True(t, isAuthenticationError(errors.New("test-error"), altoolResult{ProductErrors: []productError{{Code: -1011, UserInfo: userInfo{Status: "401"}}}}, ""))
+
+	require.False(t, isAuthenticationError(apiError{statusCode: 403}, altoolResult{}, ""))
+	require.False(t, isAuthenticationError(errors.New("test-error"), altoolResult{}, requestTimedOut))
+}
+
+func Test_fallbackUploader(t *testing.T) {
+	authErr := errors.New("Unable to authenticate. (-19209)")
+	authFailure := altoolResult{ProductErrors: []productError{{Code: -19209}}}
+
+	tests := []struct {
+		name        string
+		results     map[string]error
+		wantSources []string
+		wantErr     error
+		wantCurrent int
+	}{
+		{
+			name:        "First source works",
+			results:     map[string]error{"connection": nil},
+			wantSources: []string{"connection"},
+		},
+		{
+			name:        "Falls back on authentication error",
+			results:     map[string]error{"connection": authErr, "inputs": nil},
+			wantSources: []string{"connection", "inputs"},
+			wantCurrent: 1,
+		},
+		{
+			name:        "No fallback on other errors",
+			results:     map[string]error{"connection": errors.New("test-error")},
+			wantSources: []string{"connection"},
+			wantErr:     errors.New("test-error"),
+		},
+		{
+			name:        "Every source rejected",
+			results:     map[string]error{"connection": authErr, "inputs": authErr},
+			wantSources: []string{"connection", "inputs"},
+			wantErr:     credentialsRejectedError{err: authErr},
+			wantCurrent: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chain := newAuthChain([]authCandidate{{source: "connection"}, {source: "inputs"}})
+			var sources []string
+			fallback := newFallbackUploader(log.NewLogger(), chain, func(candidate authCandidate) (uploader, error) {
+				sources = append(sources, candidate.source)
+				candidateUploader := newMockUploader(t)
+				if err := tt.results[candidate.source]; errors.Is(err, authErr) {
+					candidateUploader.On("upload").Return("", "", authFailure, err).Once()
+				} else {
+					candidateUploader.On("upload").Return("", "", altoolResult{}, err).Once()
+				}
+				return candidateUploader, nil
+			})
+
+			_, _, _, err := fallback.upload()
+
+			require.Equal(t, tt.wantErr, err)
+			require.Equal(t, tt.wantSources, sources)
+			current, _ := chain.get()
+			require.Equal(t, tt.wantCurrent, current)
+		})
+	}
+}
+
+func Test_authChain_fallback(t *testing.T) {
+	chain := newAuthChain([]authCandidate{{source: "connection"}, {source: "inputs"}})
+
+	index, candidate, ok := chain.fallback(0)
+	require.True(t, ok)
+	require.Equal(t, 1, index)
+	require.Equal(t, "inputs", candidate.source)
+
+	// Another artifact rejected the first source too, it continues with the source already in use
+	index, candidate, ok = chain.fallback(0)
+	require.True(t, ok)
+	require.Equal(t, 1, index)
+	require.Equal(t, "inputs", candidate.source)
+
+	_, _, ok = chain.fallback(1)
+	require.False(t, ok)
+}
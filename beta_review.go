@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/bitrise-io/go-utils/v2/log"
+)
+
+// betaReviewDetails are the contact and demo account details shown to the TestFlight beta app reviewer,
+// empty fields are left unchanged in App Store Connect.
+type betaReviewDetails struct {
+	ContactFirstName    string `json:"contactFirstName,omitempty"`
+	ContactLastName     string `json:"contactLastName,omitempty"`
+	ContactEmail        string `json:"contactEmail,omitempty"`
+	ContactPhone        string `json:"contactPhone,omitempty"`
+	DemoAccountName     string `json:"demoAccountName,omitempty"`
+	DemoAccountPassword string `json:"demoAccountPassword,omitempty"`
+	DemoAccountRequired *bool  `json:"demoAccountRequired,omitempty"`
+	Notes               string `json:"notes,omitempty"`
+}
+
+func (d betaReviewDetails) isEmpty() bool {
+	return d == betaReviewDetails{}
+}
+
+type betaAppReviewSubmissionAttributes struct {
+	BetaReviewState string `json:"betaReviewState"`
+	SubmittedDate   string `json:"submittedDate"`
+}
+
+type optionalResourceDocument struct {
+	Data *resource `json:"data"`
+}
+
+func updateBetaReviewDetails(ctx context.Context, client *appStoreConnectClient, appID string, details betaReviewDetails) error {
+	var response resourceDocument
+	if err := client.get(ctx, "/v1/apps/"+appID+"/betaAppReviewDetail", &response); err != nil {
+		return fmt.Errorf("failed to get beta review details: %w", err)
+	}
+
+	attributes, err := json.Marshal(details)
+	if err != nil {
+		return err
+	}
+	request := resourceDocument{Data: resource{
+		Type:       "betaAppReviewDetails",
+		ID:         response.Data.ID,
+		Attributes: attributes,
+	}}
+	if err := client.patch(ctx, "/v1/betaAppReviewDetails/"+response.Data.ID, request, nil); err != nil {
+		return fmt.Errorf("failed to update beta review details: %w", err)
+	}
+
+	return nil
+}
+
+// submitForBetaReview submits the build for TestFlight beta app review and returns the review state,
+// a build that was already submitted is not submitted again.
+func submitForBetaReview(ctx context.Context, logger log.Logger, client *appStoreConnectClient, buildID string) (string, error) {
+	var existing optionalResourceDocument
+	if err := client.get(ctx, "/v1/builds/"+buildID+"/betaAppReviewSubmission", &existing); err != nil {
+		return "", fmt.Errorf("failed to get beta review submission: %w", err)
+	}
+	if existing.Data != nil {
+		logger.Printf("Build is already submitted for beta review")
+		return decodeBetaReviewState(*existing.Data)
+	}
+
+	request := resourceDocument{Data: resource{
+		Type: "betaAppReviewSubmissions",
+		Relationships: map[string]relationship{
+			"build": {Data: resourceIdentifier{Type: "builds", ID: buildID}},
+		},
+	}}
+	var response resourceDocument
+	if err := client.post(ctx, "/v1/betaAppReviewSubmissions", request, &response); err != nil {
+		return "", fmt.Errorf("failed to submit build for beta review: %w", err)
+	}
+
+	return decodeBetaReviewState(response.Data)
+}
+
+func decodeBetaReviewState(submission resource) (string, error) {
+	if len(submission.Attributes) == 0 {
+		return "", nil
+	}
+
+	var attributes betaAppReviewSubmissionAttributes
+	if err := json.Unmarshal(submission.Attributes, &attributes); err != nil {
+		return "", fmt.Errorf("failed to decode beta review submission: %w", err)
+	}
+
+	return attributes.BetaReviewState, nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bitrise-io/go-utils/v2/log"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_submitForBetaReview(t *testing.T) {
+	tests := []struct {
+		name           string
+		existing       string
+		submitStatus   int
+		submitResponse string
+		wantState      string
+		wantSubmitted  bool
+		wantErr        string
+	}{
+		{
+			name:           "New submission",
+			existing:       `{"data": null}`,
+			submitStatus:   http.StatusCreated,
+			submitResponse: `{"data": {"type": "betaAppReviewSubmissions", "id": "sub-1", "attributes": {"betaReviewState": "WAITING_FOR_REVIEW"}}}`,
+			wantState:      "WAITING_FOR_REVIEW",
+			wantSubmitted:  true,
+		},
+		{
+			name:      "Already submitted",
+			existing:  `{"data": {"type": "betaAppReviewSubmissions", "id": "sub-1", "attributes": {"betaReviewState": "IN_REVIEW"}}}`,
+			wantState: "IN_REVIEW",
+		},
+		{
+			name:           "Submission rejected by the API",
+			existing:       `{"data": null}`,
+			submitStatus:   http.StatusUnprocessableEntity,
+			submitResponse: `{"errors": [{"status": "422", "code": "STATE_ERROR.ENTITY_STATE_INVALID", "title": "Build is not in a valid state", "detail": "Missing What to Test notes."}]}`,
+			wantSubmitted:  true,
+			wantErr:        "failed to submit build for beta review: Build is not in a valid state (422)  Missing What to Test notes.  (code: STATE_ERROR.ENTITY_STATE_INVALID)",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			submitted := false
+			mux := http.NewServeMux()
+			mux.HandleFunc("GET /v1/builds/build-1/betaAppReviewSubmission", func(w http.ResponseWriter, r *http.Request) {
+				writeJSON(t, w, http.StatusOK, tt.existing)
+			})
+			mux.HandleFunc("POST /v1/betaAppReviewSubmissions", func(w http.ResponseWriter, r *http.Request) {
+				var request resourceDocument
+				require.NoError(t, json.NewDecoder(r.Body).Decode(&request))
+				require.Equal(t, map[string]interface{}{"type": "builds", "id": "build-1"}, request.Data.Relationships["build"].Data)
+				submitted = true
+				writeJSON(t, w, tt.submitStatus, tt.submitResponse)
+			})
+			server := httptest.NewServer(mux)
+			defer server.Close()
+			client := newAppStoreConnectClient(server.Client(), server.URL, staticTokenSource("test-token"))
+
+			state, err := submitForBetaReview(context.Background(), log.NewLogger(), client, "build-1")
+
+			require.Equal(t, tt.wantSubmitted, submitted)
+			if tt.wantErr != "" {
+				require.EqualError(t, err, tt.wantErr)
+				var apiErr apiError
+				require.True(t, errors.As(err, &apiErr))
+				require.Equal(t, "STATE_ERROR.ENTITY_STATE_INVALID", apiErr.productErrors()[0].UserInfo.IrisCode)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.wantState, state)
+		})
+	}
+}
+
+func Test_updateBetaReviewDetails(t *testing.T) {
+	var patched map[string]interface{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/apps/1023456789/betaAppReviewDetail", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(t, w, http.StatusOK, `{"data": {"type": "betaAppReviewDetails", "id": "detail-1"}}`)
+	})
+	mux.HandleFunc("PATCH /v1/betaAppReviewDetails/detail-1", func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			Data struct {
+				Attributes map[string]interface{} `json:"attributes"`
+			} `json:"data"`
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&request))
+		patched = request.Data.Attributes
+		writeJSON(t, w, http.StatusOK, `{"data": {"type": "betaAppReviewDetails", "id": "detail-1"}}`)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	client := newAppStoreConnectClient(server.Client(), server.URL, staticTokenSource("test-token"))
+
+	cfg := Config{
+		BetaReviewContactEmail:        " tester@example.com ",
+		BetaReviewDemoAccountName:     "demo",
+		BetaReviewDemoAccountPassword: "secret",
+	}
+	err := updateBetaReviewDetails(context.Background(), client, "1023456789", cfg.betaReviewDetails())
+
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{
+		"contactEmail":        "tester@example.com",
+		"demoAccountName":     "demo",
+		"demoAccountPassword": "secret",
+		"demoAccountRequired": true,
+	}, patched)
+	require.True(t, Config{}.betaReviewDetails().isEmpty())
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/bitrise-io/go-utils/v2/log"
+)
+
+const (
+	processingStateProcessing = "PROCESSING"
+	processingStateFailed     = "FAILED"
+	processingStateInvalid    = "INVALID"
+	processingStateValid      = "VALID"
+)
+
+type buildAttributes struct {
+	Version         string `json:"version"`
+	ProcessingState string `json:"processingState"`
+	UploadedDate    string `json:"uploadedDate"`
+}
+
+type build struct {
+	id         string
+	attributes buildAttributes
+}
+
+// buildProcessingWaiter polls App Store Connect until the uploaded build is processed
+type buildProcessingWaiter struct {
+	logger       log.Logger
+	client       *appStoreConnectClient
+	pollInterval time.Duration
+	timeout      time.Duration
+}
+
+func newBuildProcessingWaiter(logger log.Logger, client *appStoreConnectClient, pollInterval, timeout time.Duration) buildProcessingWaiter {
+	return buildProcessingWaiter{
+		logger:       logger,
+		client:       client,
+		pollInterval: pollInterval,
+		timeout:      timeout,
+	}
+}
+
+// findBuild looks up the build by CFBundleVersion and CFBundleShortVersionString, returns nil if it is not (yet) available.
+func (w buildProcessingWaiter) findBuild(ctx context.Context, appID string, details packageDetails, platform platformType) (*build, error) {
+	query := url.Values{}
+	query.Set("filter[app]", appID)
+	query.Set("filter[version]", details.bundleVersion)
+	query.Set("filter[preReleaseVersion.version]", details.bundleShortVersionString)
+	query.Set("filter[preReleaseVersion.platform]", apiPlatform(platform))
+	query.Set("fields[builds]", "version,processingState,uploadedDate")
+	query.Set("limit", "1")
+
+	var response resourceCollection
+	if err := w.client.get(ctx, "/v1/builds?"+query.Encode(), &response); err != nil {
+		return nil, err
+	}
+	if len(response.Data) == 0 {
+		return nil, nil
+	}
+
+	var attributes buildAttributes
+	if err := json.Unmarshal(response.Data[0].Attributes, &attributes); err != nil {
+		return nil, fmt.Errorf("failed to decode build: %w", err)
+	}
+
+	return &build{id: response.Data[0].ID, attributes: attributes}, nil
+}
+
+// waitForProcessing returns the build once its processing state is VALID,
+// fails if processing ends in the INVALID or FAILED state or does not finish in time.
+func (w buildProcessingWaiter) waitForProcessing(ctx context.Context, appID string, details packageDetails, platform platformType) (build, error) {
+	buildName := fmt.Sprintf("%s (%s)", details.bundleShortVersionString, details.bundleVersion)
+	w.logger.Infof("Waiting for App Store Connect to process build %s of %s ...", buildName, details.bundleID)
+
+	deadline := time.Now().Add(w.timeout)
+	lastState := ""
+	for {
+		b, err := w.findBuild(ctx, appID, details, platform)
+		if err != nil {
+			return build{}, fmt.Errorf("failed to query build %s: %w", buildName, err)
+		}
+
+		state := ""
+		if b != nil {
+			state = b.attributes.ProcessingState
+		}
+		if state != lastState {
+			if state == "" {
+				w.logger.Printf("Build %s is not yet available in App Store Connect", buildName)
+			} else {
+				w.logger.Printf("Build %s processing state: %s", buildName, state)
+			}
+			lastState = state
+		}
+
+		switch state {
+		case processingStateValid:
+			return *b, nil
+		case processingStateInvalid, processingStateFailed:
+			return *b, fmt.Errorf("build %s processing ended with %s state, check the email sent by App Store Connect for the reason", buildName, state)
+		}
+
+		if time.Now().After(deadline) {
+			if state == "" {
+				return build{}, fmt.Errorf("build %s did not appear in App Store Connect in %s", buildName, w.timeout)
+			}
+			return *b, fmt.Errorf("build %s is still in %s state after %s", buildName, state, w.timeout)
+		}
+		if err := sleepContext(ctx, w.pollInterval); err != nil {
+			return build{}, err
+		}
+	}
+}
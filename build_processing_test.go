@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bitrise-io/go-utils/v2/log"
+	"github.com/stretchr/testify/require"
+)
+
+// newFakeBuildsServer serves the given processing states for consecutive build queries,
+// an empty state means the build is not yet available.
+func newFakeBuildsServer(t *testing.T, states []string) (*httptest.Server, *int) {
+	queries := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/v1/builds", r.URL.Path)
+		require.Equal(t, "1023456789", r.URL.Query().Get("filter[app]"))
+		require.Equal(t, "42", r.URL.Query().Get("filter[version]"))
+		require.Equal(t, "1.0", r.URL.Query().Get("filter[preReleaseVersion.version]"))
+		require.Equal(t, "IOS", r.URL.Query().Get("filter[preReleaseVersion.platform]"))
+
+		state := states[min(queries, len(states)-1)]
+		queries++
+		if state == "" {
+			writeJSON(t, w, http.StatusOK, `{"data": []}`)
+			return
+		}
+		writeJSON(t, w, http.StatusOK, fmt.Sprintf(`{"data": [{"type": "builds", "id": "build-1", "attributes": {"version": "42", "processingState": "%s"}}]}`, state))
+	}))
+	t.Cleanup(server.Close)
+
+	return server, &queries
+}
+
+func Test_buildProcessingWaiter_waitForProcessing(t *testing.T) {
+	details := packageDetails{bundleID: "com.example.app", bundleVersion: "42", bundleShortVersionString: "1.0"}
+
+	tests := []struct {
+		name        string
+		states      []string
+		timeout     time.Duration
+		wantQueries int
+		wantErr     string
+	}{
+		{
+			name:        "Build appears and becomes valid",
+			states:      []string{"", processingStateProcessing, processingStateProcessing, processingStateValid},
+			timeout:     time.Second,
+			wantQueries: 4,
+		},
+		{
+			name:    "Build processing fails",
+			states:  []string{processingStateProcessing, processingStateInvalid},
+			timeout: time.Second,
+			wantErr: "build 1.0 (42) processing ended with INVALID state",
+		},
+		{
+			name:    "Build does not appear",
+			states:  []string{""},
+			timeout: 0,
+			wantErr: "build 1.0 (42) did not appear in App Store Connect in 0s",
+		},
+		{
+			name:    "Build processing times out",
+			states:  []string{processingStateProcessing},
+			timeout: 0,
+			wantErr: "build 1.0 (42) is still in PROCESSING state after 0s",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, queries := newFakeBuildsServer(t, tt.states)
+			client := newAppStoreConnectClient(server.Client(), server.URL, staticTokenSource("test-token"))
+			waiter := newBuildProcessingWaiter(log.NewLogger(), client, time.Millisecond, tt.timeout)
+
+			got, err := waiter.waitForProcessing(context.Background(), "1023456789", details, iOS)
+			if tt.wantErr != "" {
+				require.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, "build-1", got.id)
+			require.Equal(t, processingStateValid, got.attributes.ProcessingState)
+			require.Equal(t, tt.wantQueries, *queries)
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"debug/macho"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/bitrise-io/go-xcode/plistutil"
+)
+
+// Code signature format, see cs_blobs.h in Apple's xnu sources
+const (
+	loadCmdCodeSignature = 0x1d
+
+	csMagicEmbeddedSignature    = 0xfade0cc0
+	csMagicEmbeddedEntitlements = 0xfade7171
+	csSlotEntitlements          = 5
+
+	// codeSignatureMaxSize limits the memory used by a corrupt binary
+	codeSignatureMaxSize = 16 << 20
+)
+
+var errNoCodeSignature = errors.New("the executable is not signed")
+
+// readSignedEntitlements returns the entitlements of the executable's code signature, in case of a universal binary
+// the first architecture's. The executable is not signed if it has no code signature, nil entitlements mean none.
+func readSignedEntitlements(f *os.File) (plistutil.PlistData, error) {
+	// A universal binary contains a thin binary for every architecture, each with its own code signature
+	if fat, err := macho.NewFatFile(f); err == nil {
+		defer func() {
+			_ = fat.Close()
+		}()
+		if len(fat.Arches) == 0 {
+			return nil, errors.New("universal binary has no architectures")
+		}
+		arch := fat.Arches[0]
+		return signedEntitlements(arch.File, io.NewSectionReader(f, int64(arch.Offset), int64(arch.Size)))
+	} else if !errors.Is(err, macho.ErrNotFat) {
+		return nil, fmt.Errorf("failed to parse executable: %w", err)
+	}
+
+	thin, err := macho.NewFile(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse executable: %w", err)
+	}
+	defer func() {
+		_ = thin.Close()
+	}()
+
+	return signedEntitlements(thin, f)
+}
+
+// signedEntitlements finds the entitlements blob of the code signature, r reads the thin binary
+func signedEntitlements(f *macho.File, r io.ReaderAt) (plistutil.PlistData, error) {
+	var signature []byte
+	for _, load := range f.Loads {
+		raw := load.Raw()
+		if len(raw) < 16 || f.ByteOrder.Uint32(raw) != loadCmdCodeSignature {
+			continue
+		}
+
+		// linkedit_data_command: cmd, cmdsize, dataoff, datasize
+		offset, size := f.ByteOrder.Uint32(raw[8:]), f.ByteOrder.Uint32(raw[12:])
+		if size > codeSignatureMaxSize {
+			return nil, fmt.Errorf("code signature too large: %d bytes", size)
+		}
+		signature = make([]byte, size)
+		if _, err := r.ReadAt(signature, int64(offset)); err != nil {
+			return nil, fmt.Errorf("failed to read code signature: %w", err)
+		}
+		break
+	}
+	if signature == nil {
+		return nil, errNoCodeSignature
+	}
+
+	// The code signature is a big endian super blob: magic, length, count, then count (type, offset) index entries
+	if len(signature) < 12 || binary.BigEndian.Uint32(signature) != csMagicEmbeddedSignature {
+		return nil, errors.New("invalid code signature")
+	}
+	count := binary.BigEndian.Uint32(signature[8:])
+	for i := uint32(0); i < count; i++ {
+		entry := 12 + 8*int(i)
+		if entry+8 > len(signature) {
+			return nil, errors.New("invalid code signature")
+		}
+		if binary.BigEndian.Uint32(signature[entry:]) != csSlotEntitlements {
+			continue
+		}
+
+		// The entitlements blob: magic, length (including the 8 byte header), then the XML property list
+		offset := int(binary.BigEndian.Uint32(signature[entry+4:]))
+		if offset+8 > len(signature) || binary.BigEndian.Uint32(signature[offset:]) != csMagicEmbeddedEntitlements {
+			return nil, errors.New("invalid entitlements in code signature")
+		}
+		length := int(binary.BigEndian.Uint32(signature[offset+4:]))
+		if length < 8 || offset+length > len(signature) {
+			return nil, errors.New("invalid entitlements in code signature")
+		}
+
+		entitlements, err := plistutil.NewPlistDataFromContent(string(signature[offset+8 : offset+length]))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse entitlements: %w", err)
+		}
+		return entitlements, nil
+	}
+
+	return nil, nil
+}
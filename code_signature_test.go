@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bitrise-io/go-xcode/plistutil"
+	"github.com/stretchr/testify/require"
+)
+
+const testCPUTypeARM64 = 0x0100000c
+
+// newTestExecutable returns a minimal arm64 Mach-O executable, signed with the entitlements if signed is set,
+// an empty entitlements string means a code signature without entitlements
+func newTestExecutable(signed bool, entitlements string) []byte {
+	var signature []byte
+	if signed {
+		blobs := 0
+		if entitlements != "" {
+			blobs = 1
+		}
+		signature = make([]byte, 12+8*blobs)
+		binary.BigEndian.PutUint32(signature, csMagicEmbeddedSignature)
+		binary.BigEndian.PutUint32(signature[8:], uint32(blobs))
+		if blobs == 1 {
+			binary.BigEndian.PutUint32(signature[12:], csSlotEntitlements)
+			binary.BigEndian.PutUint32(signature[16:], uint32(len(signature)))
+			blob := make([]byte, 8, 8+len(entitlements))
+			binary.BigEndian.PutUint32(blob, csMagicEmbeddedEntitlements)
+			binary.BigEndian.PutUint32(blob[4:], uint32(8+len(entitlements)))
+			signature = append(signature, append(blob, entitlements...)...)
+		}
+		binary.BigEndian.PutUint32(signature[4:], uint32(len(signature)))
+	}
+
+	// mach_header_64: magic, cputype, cpusubtype, filetype (MH_EXECUTE), ncmds, sizeofcmds, flags, reserved
+	executable := make([]byte, 32)
+	binary.LittleEndian.PutUint32(executable, 0xfeedfacf)
+	binary.LittleEndian.PutUint32(executable[4:], testCPUTypeARM64)
+	binary.LittleEndian.PutUint32(executable[12:], 2)
+	if signed {
+		binary.LittleEndian.PutUint32(executable[16:], 1)
+		binary.LittleEndian.PutUint32(executable[20:], 16)
+
+		// linkedit_data_command: cmd, cmdsize, dataoff, datasize
+		load := make([]byte, 16)
+		binary.LittleEndian.PutUint32(load, loadCmdCodeSignature)
+		binary.LittleEndian.PutUint32(load[4:], 16)
+		binary.LittleEndian.PutUint32(load[8:], 48)
+		binary.LittleEndian.PutUint32(load[12:], uint32(len(signature)))
+		executable = append(append(executable, load...), signature...)
+	}
+
+	return executable
+}
+
+// newTestUniversalExecutable wraps the thin arm64 executable in a universal binary
+func newTestUniversalExecutable(thin []byte) []byte {
+	const offset = 64
+
+	// fat_header: magic, nfat_arch, then fat_arch: cputype, cpusubtype, offset, size, align
+	executable := make([]byte, offset)
+	binary.BigEndian.PutUint32(executable, 0xcafebabe)
+	binary.BigEndian.PutUint32(executable[4:], 1)
+	binary.BigEndian.PutUint32(executable[8:], testCPUTypeARM64)
+	binary.BigEndian.PutUint32(executable[16:], offset)
+	binary.BigEndian.PutUint32(executable[20:], uint32(len(thin)))
+	binary.BigEndian.PutUint32(executable[24:], 6)
+
+	return append(executable, thin...)
+}
+
+func Test_readSignedEntitlements(t *testing.T) {
+	entitlements := testPlist(map[string]interface{}{
+		"application-identifier": "ABCDE12345.com.example.app",
+		"get-task-allow":         false,
+	})
+	want := plistutil.PlistData{
+		"application-identifier": "ABCDE12345.com.example.app",
+		"get-task-allow":         false,
+	}
+
+	tests := []struct {
+		name       string
+		executable []byte
+		want       plistutil.PlistData
+		wantErr    error
+	}{
+		{name: "Signed executable", executable: newTestExecutable(true, entitlements), want: want},
+		{name: "Universal binary", executable: newTestUniversalExecutable(newTestExecutable(true, entitlements)), want: want},
+		{name: "Signed without entitlements", executable: newTestExecutable(true, "")},
+		{name: "Not signed", executable: newTestExecutable(false, ""), wantErr: errNoCodeSignature},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pth := filepath.Join(t.TempDir(), "Example")
+			require.NoError(t, os.WriteFile(pth, tt.executable, 0700))
+			f, err := os.Open(pth)
+			require.NoError(t, err)
+			defer func() {
+				require.NoError(t, f.Close())
+			}()
+
+			got, err := readSignedEntitlements(f)
+
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_readSignedEntitlements_notMachO(t *testing.T) {
+	pth := filepath.Join(t.TempDir(), "Example")
+	require.NoError(t, os.WriteFile(pth, []byte("#!/bin/sh\n"), 0700))
+	f, err := os.Open(pth)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, f.Close())
+	}()
+
+	_, err = readSignedEntitlements(f)
+
+	require.EqualError(t, err, "failed to parse executable: invalid magic number in record at byte 0x0")
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"crypto/elliptic"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bitrise-io/go-xcode/devportalservice"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_validateAPIKey(t *testing.T) {
+	_, privateKey := generateTestAPIKey(t, elliptic.P256())
+	_, p384Key := generateTestAPIKey(t, elliptic.P384())
+
+	tests := []struct {
+		name        string
+		apiKey      devportalservice.APIKeyConnection
+		wantProblem string
+	}{
+		{
+			name:   "Team key",
+			apiKey: devportalservice.APIKeyConnection{KeyID: "ABCDE12345", IssuerID: "57246542-96fe-1a63-e053-0824d011072a", PrivateKey: privateKey},
+		},
+		{
+			name:   "Individual key",
+			apiKey: devportalservice.APIKeyConnection{KeyID: "ABCDE12345", PrivateKey: privateKey},
+		},
+		{
+			name:        "Key ID with file name",
+			apiKey:      devportalservice.APIKeyConnection{KeyID: "AuthKey_ABCDE12345.p8", IssuerID: "57246542-96fe-1a63-e053-0824d011072a", PrivateKey: privateKey},
+			wantProblem: `invalid API key ID: "AuthKey_ABCDE12345.p8"`,
+		},
+		{
+			name:        "Issuer ID is not a UUID",
+			apiKey:      devportalservice.APIKeyConnection{KeyID: "ABCDE12345", IssuerID: "ABCDE12345", PrivateKey: privateKey},
+			wantProblem: `invalid API issuer ID: "ABCDE12345"`,
+		},
+		{
+			name:        "Not a PEM key",
+			apiKey:      devportalservice.APIKeyConnection{KeyID: "ABCDE12345", IssuerID: "57246542-96fe-1a63-e053-0824d011072a", PrivateKey: "MIGTAgEAMBMGByqGSM49AgEGCCqGSM49AwEHBHkwdwIBAQQg"},
+			wantProblem: "invalid API private key: private key is not PEM encoded",
+		},
+		{
+			name:        "Not a P-256 key",
+			apiKey:      devportalservice.APIKeyConnection{KeyID: "ABCDE12345", IssuerID: "57246542-96fe-1a63-e053-0824d011072a", PrivateKey: p384Key},
+			wantProblem: "invalid API private key: private key is not a P-256 EC key (P-384)",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateAPIKey(tt.apiKey)
+			if tt.wantProblem == "" {
+				require.NoError(t, err)
+				return
+			}
+
+			var credentialErr actionableError
+			require.ErrorAs(t, err, &credentialErr)
+			require.Equal(t, tt.wantProblem, credentialErr.problem)
+			require.NotEmpty(t, credentialErr.advice)
+		})
+	}
+}
+
+func Test_verifyAPIKeyAccess(t *testing.T) {
+	tests := []struct {
+		name              string
+		status            int
+		body              string
+		wantCredentialErr bool
+		wantErr           bool
+	}{
+		{name: "Key accepted", status: http.StatusOK, body: `{"data": []}`},
+		{
+			name:              "Key rejected",
+			status:            http.StatusUnauthorized,
+			body:              `{"errors": [{"status": "401", "code": "NOT_AUTHORIZED", "title": "Authentication credentials are missing or invalid."}]}`,
+			wantCredentialErr: true,
+		},
+		{
+			name:              "Key without access",
+			status:            http.StatusForbidden,
+			body:              `{"errors": [{"status": "403", "code": "FORBIDDEN_ERROR", "title": "This request is forbidden for security reasons"}]}`,
+			wantCredentialErr: true,
+		},
+		{
+			name:    "Server error",
+			status:  http.StatusServiceUnavailable,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				require.Equal(t, "/v1/apps", r.URL.Path)
+				require.Equal(t, "Bearer test-token", r.Header.Get("Authorization"))
+				writeJSON(t, w, tt.status, tt.body)
+			}))
+			defer server.Close()
+			client := newAppStoreConnectClient(server.Client(), server.URL, staticTokenSource("test-token"))
+
+			err := verifyAPIKeyAccess(context.Background(), client)
+
+			var credentialErr actionableError
+			require.Equal(t, tt.wantCredentialErr, errors.As(err, &credentialErr))
+			require.Equal(t, tt.wantCredentialErr || tt.wantErr, err != nil)
+		})
+	}
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/v2/log"
+	"github.com/bitrise-io/go-xcode/plistutil"
+	"github.com/bitrise-io/go-xcode/profileutil"
+)
+
+// identifierEntitlements are the entitlements identifying the app and the team, these have to match the provisioning profile
+var identifierEntitlements = map[string]bool{
+	"application-identifier":              true,
+	"com.apple.application-identifier":    true,
+	"com.apple.developer.team-identifier": true,
+}
+
+type issueSeverity string
+
+const (
+	severityError   issueSeverity = "error"
+	severityWarning issueSeverity = "warning"
+)
+
+// entitlementsIssue is a problem found in the entitlements of a bundle
+type entitlementsIssue struct {
+	bundle   string
+	severity issueSeverity
+	message  string
+}
+
+// lintEntitlements compares the signed entitlements of the app and of every nested bundle with the entitlements
+// granted by the bundle's embedded provisioning profile
+func lintEntitlements(pth string) ([]entitlementsIssue, error) {
+	archive, err := openIPA(pth)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = archive.close()
+	}()
+
+	bundles, err := archive.bundles()
+	if err != nil {
+		return nil, err
+	}
+
+	var issues []entitlementsIssue
+	for _, bundle := range bundles {
+		issue := func(severity issueSeverity, format string, v ...interface{}) {
+			issues = append(issues, entitlementsIssue{bundle: bundle.name(), severity: severity, message: fmt.Sprintf(format, v...)})
+		}
+
+		profile, err := archive.provisioningProfile(bundle)
+		if err != nil {
+			issue(severityWarning, "no valid embedded provisioning profile: %s", err)
+			continue
+		}
+
+		signed, err := readBundleEntitlements(archive, bundle)
+		if errors.Is(err, errNoCodeSignature) {
+			issue(severityWarning, "the executable is not signed")
+			continue
+		} else if err != nil {
+			issue(severityWarning, "could not read the signed entitlements: %s", err)
+			continue
+		}
+
+		for _, bundleIssue := range lintBundleEntitlements(bundle.bundleID(), signed, profile) {
+			issue(bundleIssue.severity, "%s", bundleIssue.message)
+		}
+	}
+
+	return issues, nil
+}
+
+func readBundleEntitlements(archive *ipaArchive, bundle ipaBundle) (plistutil.PlistData, error) {
+	executable, err := bundle.executablePath()
+	if err != nil {
+		return nil, err
+	}
+	f, remove, err := archive.extractFile(executable)
+	if err != nil {
+		return nil, err
+	}
+	defer remove()
+
+	return readSignedEntitlements(f)
+}
+
+// lintBundleEntitlements checks the signed entitlements of a bundle against its provisioning profile,
+// the returned issues have no bundle set. Development-only values, a missing beta-reports-active and identifiers
+// not matching the profile are errors, as App Store Connect rejects them. Other mismatches with the profile are warnings,
+// the profile's capability list might be incomplete.
+func lintBundleEntitlements(bundleID string, signed plistutil.PlistData, profile profileutil.ProvisioningProfileInfoModel) []entitlementsIssue {
+	var issues []entitlementsIssue
+	issue := func(severity issueSeverity, format string, v ...interface{}) {
+		issues = append(issues, entitlementsIssue{severity: severity, message: fmt.Sprintf(format, v...)})
+	}
+	granted := profile.Entitlements
+	if signed == nil {
+		signed = plistutil.PlistData{}
+	}
+
+	// Development-only values
+	if allow, _ := signed.GetBool("get-task-allow"); allow {
+		issue(severityError, "get-task-allow is true, the bundle is signed for debugging")
+	}
+	for _, key := range []string{"aps-environment", "com.apple.developer.aps-environment"} {
+		if environment, _ := signed.GetString(key); environment == "development" {
+			issue(severityError, "%s is development, push notifications of App Store builds use the production environment", key)
+		}
+	}
+	if active, _ := signed.GetBool("beta-reports-active"); !active {
+		if grantedActive, _ := granted.GetBool("beta-reports-active"); grantedActive {
+			issue(severityError, "beta-reports-active is missing, TestFlight requires it (Xcode adds it when exporting for App Store Connect)")
+		} else {
+			issue(severityError, "beta-reports-active is missing, and the provisioning profile (%s) does not grant it", profile.Name)
+		}
+	}
+
+	// Identifiers
+	applicationID, _ := signed.GetString("application-identifier")
+	if applicationID == "" {
+		applicationID, _ = signed.GetString("com.apple.application-identifier")
+	}
+	if expected := profile.TeamID + "." + bundleID; applicationID != expected {
+		issue(severityError, "application-identifier (%s) does not match the team and bundle ID (%s)", applicationID, expected)
+	}
+	if teamID, ok := signed.GetString("com.apple.developer.team-identifier"); ok && teamID != profile.TeamID {
+		issue(severityError, "com.apple.developer.team-identifier (%s) does not match the team of the provisioning profile (%s)", teamID, profile.TeamID)
+	}
+
+	// Every signed entitlement has to be granted by the profile
+	missingCapabilities := map[string]bool{}
+	for _, key := range profileutil.MatchTargetAndProfileEntitlements(signed, granted, profile.Type) {
+		missingCapabilities[key] = true
+	}
+	keys := make([]string, 0, len(signed))
+	for key := range signed {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if key == "get-task-allow" || key == "beta-reports-active" {
+			continue
+		}
+		grantedValue, ok := granted[key]
+		switch {
+		case missingCapabilities[key]:
+			issue(severityWarning, "%s is not granted by the provisioning profile (%s), enable the capability for the App ID and regenerate the profile", key, profile.Name)
+		case !ok:
+			issue(severityWarning, "%s is not in the provisioning profile (%s)", key, profile.Name)
+		case !entitlementValueAllowed(signed[key], grantedValue):
+			severity := severityWarning
+			if identifierEntitlements[key] {
+				severity = severityError
+			}
+			issue(severity, "%s (%v) does not match the provisioning profile (%v)", key, signed[key], grantedValue)
+		}
+	}
+
+	return issues
+}
+
+// entitlementValueAllowed tells whether the profile grants the signed value, profiles might grant wildcards
+// (for example ABCDE12345.* or *) and lists of values
+func entitlementValueAllowed(signed, granted interface{}) bool {
+	switch grantedValue := granted.(type) {
+	case string:
+		switch signedValue := signed.(type) {
+		case string:
+			return wildcardMatch(grantedValue, signedValue)
+		case []interface{}:
+			for _, item := range signedValue {
+				if s, ok := item.(string); !ok || !wildcardMatch(grantedValue, s) {
+					return false
+				}
+			}
+			return true
+		}
+	case []interface{}:
+		signedValues, ok := signed.([]interface{})
+		if !ok {
+			signedValues = []interface{}{signed}
+		}
+		for _, item := range signedValues {
+			if !grantedListContains(grantedValue, item) {
+				return false
+			}
+		}
+		return true
+	case bool:
+		// A granted capability is not required to be enabled
+		signedValue, ok := signed.(bool)
+		return ok && (signedValue == grantedValue || !signedValue)
+	}
+
+	return reflect.DeepEqual(signed, granted)
+}
+
+func grantedListContains(granted []interface{}, signed interface{}) bool {
+	for _, item := range granted {
+		pattern, isString := item.(string)
+		value, signedString := signed.(string)
+		if isString && signedString && wildcardMatch(pattern, value) {
+			return true
+		}
+		if reflect.DeepEqual(item, signed) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// wildcardMatch matches the value against the pattern, * matches any (also empty) sequence of characters
+// at any position of the pattern (for example ABCDE12345.* or ABCDE12345.*.widget)
+func wildcardMatch(pattern, value string) bool {
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return pattern == value
+	}
+	if !strings.HasPrefix(value, parts[0]) {
+		return false
+	}
+	value = value[len(parts[0]):]
+
+	last := parts[len(parts)-1]
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(value, part)
+		if i < 0 {
+			return false
+		}
+		value = value[i+len(part):]
+	}
+
+	return len(value) >= len(last) && strings.HasSuffix(value, last)
+}
+
+// preflightEntitlements lints the entitlements of the IPA before the upload, it returns an error if any error is found
+func preflightEntitlements(logger log.Logger, pth string) error {
+	// The entitlements are read from the code signature of the IPA's bundles
+	if filepath.Ext(pth) != ".ipa" {
+		return nil
+	}
+
+	logger.Println()
+	logger.Infof("Checking entitlements of %s", filepath.Base(pth))
+
+	issues, err := lintEntitlements(pth)
+	if err != nil {
+		return fmt.Errorf("Entitlements check failed: %w", err)
+	}
+
+	errorCount := 0
+	for _, issue := range issues {
+		if issue.severity == severityError {
+			logger.Errorf("- %s: %s", issue.bundle, issue.message)
+			errorCount++
+		} else {
+			logger.Warnf("- %s: %s", issue.bundle, issue.message)
+		}
+	}
+	if errorCount > 0 {
+		return fmt.Errorf("Entitlements check failed with %d errors, App Store Connect rejects these entitlements", errorCount)
+	}
+	if len(issues) == 0 {
+		logger.Donef("Entitlements match the provisioning profiles")
+	}
+
+	return nil
+}
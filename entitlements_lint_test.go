@@ -0,0 +1,248 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/bitrise-io/go-utils/v2/log"
+	"github.com/bitrise-io/go-xcode/plistutil"
+	"github.com/bitrise-io/go-xcode/profileutil"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_lintBundleEntitlements(t *testing.T) {
+	profile := profileutil.ProvisioningProfileInfoModel{
+		Name:   "Example App Store",
+		TeamID: "ABCDE12345",
+		Type:   profileutil.ProfileTypeIos,
+		Entitlements: plistutil.PlistData{
+			"application-identifier":                 "ABCDE12345.com.example.app",
+			"com.apple.developer.team-identifier":    "ABCDE12345",
+			"get-task-allow":                         false,
+			"beta-reports-active":                    true,
+			"aps-environment":                        "production",
+			"keychain-access-groups":                 []interface{}{"ABCDE12345.*"},
+			"com.apple.developer.associated-domains": "*",
+		},
+	}
+	signed := func(overrides plistutil.PlistData) plistutil.PlistData {
+		entitlements := plistutil.PlistData{
+			"application-identifier":              "ABCDE12345.com.example.app",
+			"com.apple.developer.team-identifier": "ABCDE12345",
+			"get-task-allow":                      false,
+			"beta-reports-active":                 true,
+			"aps-environment":                     "production",
+			"keychain-access-groups":              []interface{}{"ABCDE12345.com.example.app"},
+		}
+		for key, value := range overrides {
+			if value == nil {
+				delete(entitlements, key)
+			} else {
+				entitlements[key] = value
+			}
+		}
+		return entitlements
+	}
+
+	tests := []struct {
+		name   string
+		signed plistutil.PlistData
+		want   []entitlementsIssue
+	}{
+		{name: "Matching entitlements", signed: signed(nil)},
+		{
+			name:   "Wildcard values",
+			signed: signed(plistutil.PlistData{"com.apple.developer.associated-domains": []interface{}{"applinks:example.com"}}),
+		},
+		{
+			name:   "Debuggable",
+			signed: signed(plistutil.PlistData{"get-task-allow": true}),
+			want:   []entitlementsIssue{{severity: severityError, message: "get-task-allow is true, the bundle is signed for debugging"}},
+		},
+		{
+			name:   "Development push environment",
+			signed: signed(plistutil.PlistData{"aps-environment": "development"}),
+			want: []entitlementsIssue{
+				{severity: severityError, message: "aps-environment is development, push notifications of App Store builds use the production environment"},
+				{severity: severityWarning, message: "aps-environment (development) does not match the provisioning profile (production)"},
+			},
+		},
+		{
+			name:   "Missing beta-reports-active",
+			signed: signed(plistutil.PlistData{"beta-reports-active": nil}),
+			want:   []entitlementsIssue{{severity: severityError, message: "beta-reports-active is missing, TestFlight requires it (Xcode adds it when exporting for App Store Connect)"}},
+		},
+		{
+			name:   "Other bundle ID",
+			signed: signed(plistutil.PlistData{"application-identifier": "ABCDE12345.com.example.other"}),
+			want: []entitlementsIssue{
+				{severity: severityError, message: "application-identifier (ABCDE12345.com.example.other) does not match the team and bundle ID (ABCDE12345.com.example.app)"},
+				{severity: severityError, message: "application-identifier (ABCDE12345.com.example.other) does not match the provisioning profile (ABCDE12345.com.example.app)"},
+			},
+		},
+		{
+			name:   "Other team",
+			signed: signed(plistutil.PlistData{"com.apple.developer.team-identifier": "FGHIJ67890"}),
+			want: []entitlementsIssue{
+				{severity: severityError, message: "com.apple.developer.team-identifier (FGHIJ67890) does not match the team of the provisioning profile (ABCDE12345)"},
+				{severity: severityError, message: "com.apple.developer.team-identifier (FGHIJ67890) does not match the provisioning profile (ABCDE12345)"},
+			},
+		},
+		{
+			name:   "Capability not granted",
+			signed: signed(plistutil.PlistData{"com.apple.developer.healthkit": true}),
+			want: []entitlementsIssue{{
+				severity: severityWarning,
+				message:  "com.apple.developer.healthkit is not granted by the provisioning profile (Example App Store), enable the capability for the App ID and regenerate the profile",
+			}},
+		},
+		{
+			name:   "Keychain group of other team",
+			signed: signed(plistutil.PlistData{"keychain-access-groups": []interface{}{"FGHIJ67890.shared"}}),
+			want: []entitlementsIssue{{
+				severity: severityWarning,
+				message:  "keychain-access-groups ([FGHIJ67890.shared]) does not match the provisioning profile ([ABCDE12345.*])",
+			}},
+		},
+		{
+			name:   "Unknown entitlement",
+			signed: signed(plistutil.PlistData{"com.apple.developer.example": true}),
+			want:   []entitlementsIssue{{severity: severityWarning, message: "com.apple.developer.example is not in the provisioning profile (Example App Store)"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := lintBundleEntitlements("com.example.app", tt.signed, profile)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_entitlementValueAllowed(t *testing.T) {
+	tests := []struct {
+		name    string
+		signed  interface{}
+		granted interface{}
+		want    bool
+	}{
+		{name: "Equal strings", signed: "production", granted: "production", want: true},
+		{name: "Different strings", signed: "development", granted: "production"},
+		{name: "Prefix wildcard", signed: "ABCDE12345.com.example.app", granted: "ABCDE12345.*", want: true},
+		{name: "Prefix wildcard of other team", signed: "FGHIJ67890.com.example.app", granted: "ABCDE12345.*"},
+		{name: "Wildcard in the middle", signed: "ABCDE12345.com.example.app.widget", granted: "ABCDE12345.*.widget", want: true},
+		{name: "Wildcard in the middle, other suffix", signed: "ABCDE12345.com.example.app.intents", granted: "ABCDE12345.*.widget"},
+		{name: "Multiple wildcards", signed: "iCloud.com.example.app.shared", granted: "iCloud.*.app.*", want: true},
+		{name: "Wildcard string for list", signed: []interface{}{"applinks:example.com", "webcredentials:example.com"}, granted: "*", want: true},
+		{name: "Subset of list", signed: []interface{}{"group.a"}, granted: []interface{}{"group.a", "group.b"}, want: true},
+		{name: "Not subset of list", signed: []interface{}{"group.a", "group.c"}, granted: []interface{}{"group.a", "group.b"}},
+		{name: "String in list", signed: "production", granted: []interface{}{"development", "production"}, want: true},
+		{name: "Granted capability not enabled", signed: false, granted: true, want: true},
+		{name: "Capability granted disabled", signed: true, granted: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, entitlementValueAllowed(tt.signed, tt.granted))
+		})
+	}
+}
+
+func Test_preflightEntitlements(t *testing.T) {
+	appProfile := newTestProvisioningProfile(t, map[string]interface{}{
+		"Entitlements": map[string]interface{}{
+			"application-identifier":              "ABCDE12345.com.example.app",
+			"com.apple.developer.team-identifier": "ABCDE12345",
+			"get-task-allow":                      false,
+			"beta-reports-active":                 true,
+		},
+	})
+	widgetProfile := newTestProvisioningProfile(t, map[string]interface{}{
+		"Name": "Example Widget App Store",
+		"Entitlements": map[string]interface{}{
+			"application-identifier":              "ABCDE12345.com.example.app.widget",
+			"com.apple.developer.team-identifier": "ABCDE12345",
+			"get-task-allow":                      false,
+			"beta-reports-active":                 true,
+		},
+	})
+	infoPlist := func(bundleID, executable string) []byte {
+		return []byte(testPlist(map[string]interface{}{
+			"CFBundleIdentifier":         bundleID,
+			"CFBundleExecutable":         executable,
+			"CFBundleVersion":            "42",
+			"CFBundleShortVersionString": "1.0",
+		}))
+	}
+	executable := func(applicationID string, debuggable bool) []byte {
+		return newTestExecutable(true, testPlist(map[string]interface{}{
+			"application-identifier": applicationID,
+			"get-task-allow":         debuggable,
+			"beta-reports-active":    true,
+		}))
+	}
+	files := func(widgetDebuggable bool) map[string][]byte {
+		return map[string][]byte{
+			"Info.plist":                                    infoPlist("com.example.app", "Example"),
+			"Example":                                       executable("ABCDE12345.com.example.app", false),
+			"embedded.mobileprovision":                      appProfile,
+			"PlugIns/Widget.appex/Info.plist":               infoPlist("com.example.app.widget", "Widget"),
+			"PlugIns/Widget.appex/Widget":                   executable("ABCDE12345.com.example.app.widget", widgetDebuggable),
+			"PlugIns/Widget.appex/embedded.mobileprovision": widgetProfile,
+		}
+	}
+
+	tests := []struct {
+		name    string
+		files   map[string][]byte
+		mode    string
+		wantErr string
+	}{
+		{name: "Matching entitlements", files: files(false), mode: checkModeFail},
+		{
+			name:    "Debuggable extension",
+			files:   files(true),
+			mode:    checkModeFail,
+			wantErr: "Entitlements check failed with 1 errors, App Store Connect rejects these entitlements",
+		},
+		{name: "Debuggable extension, warn mode", files: files(true), mode: checkModeWarn},
+		{
+			name:    "No app",
+			files:   map[string][]byte{"Info.plist": []byte("not a plist")},
+			mode:    checkModeFail,
+			wantErr: "Entitlements check failed: failed to parse Payload/Example.app/Info.plist",
+		},
+		{name: "No app, warn mode", files: map[string][]byte{"Info.plist": []byte("not a plist")}, mode: checkModeWarn},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger := log.NewLogger()
+			pth := writeTestIPA(t, tt.files)
+			err := runCheck(logger, tt.mode, func() error { return preflightEntitlements(logger, pth) })
+
+			if tt.wantErr != "" {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
+
+func Test_lintEntitlements(t *testing.T) {
+	pth := writeTestIPA(t, map[string][]byte{
+		"Info.plist": []byte(testPlist(map[string]interface{}{
+			"CFBundleIdentifier": "com.example.app",
+			"CFBundleExecutable": "Example",
+		})),
+		"Example":                         newTestExecutable(false, ""),
+		"embedded.mobileprovision":        newTestProvisioningProfile(t, nil),
+		"PlugIns/Widget.appex/Info.plist": []byte(testPlist(map[string]interface{}{"CFBundleIdentifier": "com.example.app.widget"})),
+	})
+
+	issues, err := lintEntitlements(pth)
+
+	require.NoError(t, err)
+	require.Equal(t, []entitlementsIssue{
+		{bundle: "Example.app", severity: severityWarning, message: "the executable is not signed"},
+		{bundle: "Widget.appex", severity: severityWarning, message: "no valid embedded provisioning profile: Payload/Example.app/PlugIns/Widget.appex/embedded.mobileprovision not found in the IPA"},
+	}, issues)
+}
@@ -2,6 +2,17 @@ package main
 
 import "fmt"
 
+// actionableError is a problem found before the upload (for example an invalid credential or signature),
+// with advice on how to fix it
+type actionableError struct {
+	problem string
+	advice  string
+}
+
+func (e actionableError) Error() string {
+	return fmt.Sprintf("%s\n%s", e.problem, e.advice)
+}
+
 type uploadError struct {
 	description string
 	reason      string
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/bitrise-io/go-xcode/plistutil"
+	"github.com/bitrise-io/go-xcode/profileutil"
+)
+
+// ipaBundleContainers are the directories of a bundle, which hold the nested app and app extension bundles
+var ipaBundleContainers = []string{"PlugIns", "Extensions", "Watch", "AppClips"}
+
+// ipaArchive is an opened IPA, its files are read on demand
+type ipaArchive struct {
+	reader *zip.ReadCloser
+	files  map[string]*zip.File
+}
+
+// ipaBundle is the app, or a nested app or app extension bundle in an IPA
+type ipaBundle struct {
+	// path is the bundle's directory in the IPA, for example Payload/Example.app/PlugIns/Widget.appex
+	path      string
+	infoPlist plistutil.PlistData
+}
+
+func openIPA(pth string) (*ipaArchive, error) {
+	reader, err := zip.OpenReader(pth)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open IPA: %w", err)
+	}
+
+	files := map[string]*zip.File{}
+	for _, file := range reader.File {
+		files[path.Clean(file.Name)] = file
+	}
+
+	return &ipaArchive{reader: reader, files: files}, nil
+}
+
+func (a *ipaArchive) close() error {
+	return a.reader.Close()
+}
+
+// readFile returns the content of a small file, at most limit bytes
+func (a *ipaArchive) readFile(name string, limit int64) ([]byte, error) {
+	file, ok := a.files[name]
+	if !ok {
+		return nil, fmt.Errorf("%s not found in the IPA", name)
+	}
+	if file.UncompressedSize64 > uint64(limit) {
+		return nil, fmt.Errorf("%s is larger than %d bytes", name, limit)
+	}
+
+	r, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = r.Close()
+	}()
+
+	var content bytes.Buffer
+	if _, err := io.Copy(&content, io.LimitReader(r, limit)); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", name, err)
+	}
+
+	return content.Bytes(), nil
+}
+
+// extractFile writes the file to a temporary file, for large files which need random access (executables),
+// the returned function removes the temporary file
+func (a *ipaArchive) extractFile(name string) (*os.File, func(), error) {
+	file, ok := a.files[name]
+	if !ok {
+		return nil, nil, fmt.Errorf("%s not found in the IPA", name)
+	}
+	r, err := file.Open()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func() {
+		_ = r.Close()
+	}()
+
+	tmp, err := os.CreateTemp("", "ipa-file")
+	if err != nil {
+		return nil, nil, err
+	}
+	remove := func() {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+	}
+	if _, err := io.Copy(tmp, r); err != nil {
+		remove()
+		return nil, nil, fmt.Errorf("failed to extract %s: %w", name, err)
+	}
+
+	return tmp, remove, nil
+}
+
+// bundles returns the app bundle first, then the nested app and app extension bundles
+func (a *ipaArchive) bundles() ([]ipaBundle, error) {
+	var bundles []ipaBundle
+	for name := range a.files {
+		dir, file := path.Split(name)
+		dir = path.Clean(dir)
+		if file != "Info.plist" || !isIPABundle(dir) {
+			continue
+		}
+
+		content, err := a.readFile(name, pkgMetadataMaxSize)
+		if err != nil {
+			return nil, err
+		}
+		infoPlist, err := plistutil.NewPlistDataFromContent(string(content))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", name, err)
+		}
+		bundles = append(bundles, ipaBundle{path: dir, infoPlist: infoPlist})
+	}
+
+	sort.Slice(bundles, func(i, j int) bool {
+		depthI, depthJ := strings.Count(bundles[i].path, "/"), strings.Count(bundles[j].path, "/")
+		if depthI != depthJ {
+			return depthI < depthJ
+		}
+		return bundles[i].path < bundles[j].path
+	})
+	if len(bundles) == 0 || strings.Count(bundles[0].path, "/") != 1 {
+		return nil, fmt.Errorf("no app bundle found in the IPA")
+	}
+	if len(bundles) > 1 && strings.Count(bundles[1].path, "/") == 1 {
+		return nil, fmt.Errorf("multiple app bundles found in the IPA: %s, %s", bundles[0].name(), bundles[1].name())
+	}
+
+	return bundles, nil
+}
+
+// isIPABundle tells whether the directory is the app (Payload/*.app), or a bundle in one of the containers of a bundle
+func isIPABundle(dir string) bool {
+	ext := path.Ext(dir)
+	if ext != ".app" && ext != ".appex" {
+		return false
+	}
+
+	parent := path.Dir(dir)
+	if parent == "Payload" {
+		return ext == ".app"
+	}
+	for _, container := range ipaBundleContainers {
+		if path.Base(parent) == container && isIPABundle(path.Dir(parent)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (b ipaBundle) name() string {
+	return path.Base(b.path)
+}
+
+func (b ipaBundle) bundleID() string {
+	bundleID, _ := b.infoPlist.GetString("CFBundleIdentifier")
+	return bundleID
+}
+
+// executablePath returns the path of the bundle's main executable in the IPA
+func (b ipaBundle) executablePath() (string, error) {
+	executable, ok := b.infoPlist.GetString("CFBundleExecutable")
+	if !ok || executable == "" {
+		return "", fmt.Errorf("no CFBundleExecutable in the Info.plist of %s", b.name())
+	}
+
+	return path.Join(b.path, executable), nil
+}
+
+// provisioningProfile returns the provisioning profile embedded in the bundle
+func (a *ipaArchive) provisioningProfile(bundle ipaBundle) (profileutil.ProvisioningProfileInfoModel, error) {
+	content, err := a.readFile(path.Join(bundle.path, "embedded.mobileprovision"), pkgMetadataMaxSize)
+	if err != nil {
+		return profileutil.ProvisioningProfileInfoModel{}, err
+	}
+	profile, err := profileutil.ProvisioningProfileFromContent(content)
+	if err != nil {
+		return profileutil.ProvisioningProfileInfoModel{}, fmt.Errorf("failed to parse the provisioning profile of %s: %w", bundle.name(), err)
+	}
+	info, err := profileutil.NewProvisioningProfileInfo(*profile)
+	if err != nil {
+		return profileutil.ProvisioningProfileInfoModel{}, fmt.Errorf("failed to read the provisioning profile of %s: %w", bundle.name(), err)
+	}
+
+	return info, nil
+}
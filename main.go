@@ -2,23 +2,24 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"regexp"
 	"slices"
-	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/avast/retry-go/v4"
 	"github.com/bitrise-io/go-steputils/stepconf"
 	"github.com/bitrise-io/go-utils/command"
-	"github.com/bitrise-io/go-utils/fileutil"
-	"github.com/bitrise-io/go-utils/pathutil"
 	httpretry "github.com/bitrise-io/go-utils/retry"
-	"github.com/bitrise-io/go-utils/v2/errorutil"
 	fileutilv2 "github.com/bitrise-io/go-utils/v2/fileutil"
 	"github.com/bitrise-io/go-utils/v2/log"
 	"github.com/bitrise-io/go-xcode/appleauth"
@@ -31,14 +32,24 @@ import (
 // Config ...
 type Config struct {
 	BitriseConnection   string          `env:"connection,opt[automatic,api_key,apple_id,off]"`
+	UploadMethod        string          `env:"upload_method,opt[altool,app_store_connect_api]"`
+	Mode                string          `env:"mode,opt[upload,validate,validate_then_upload]"`
 	AppleID             string          `env:"itunescon_user"`
 	Password            stepconf.Secret `env:"password"`
 	AppSpecificPassword stepconf.Secret `env:"app_password"`
 	APIKeyPath          stepconf.Secret `env:"api_key_path"`
 	APIIssuer           string          `env:"api_issuer"`
-
-	IpaPath string `env:"ipa_path"`
-	PkgPath string `env:"pkg_path"`
+	VerifyAPIKey        bool            `env:"verify_api_key,opt[yes,no]"`
+	AuthFallback        bool            `env:"auth_fallback,opt[yes,no]"`
+
+	IpaPath                   string `env:"ipa_path"`
+	VerifyProvisioningProfile string `env:"verify_provisioning_profile,opt[fail,warn,off]"`
+	EntitlementsLint          string `env:"entitlements_lint,opt[fail,warn,off]"`
+	VerifyNestedBundles       string `env:"verify_nested_bundles,opt[fail,warn,off]"`
+	PkgPath                   string `env:"pkg_path"`
+	VerifyPKGSignature        string `env:"verify_pkg_signature,opt[fail,warn,off]"`
+	FailurePolicy             string `env:"failure_policy,opt[fail_fast,continue]"`
+	ConcurrentUploads         int    `env:"concurrent_uploads,required"`
 
 	// App details
 	Platform                 string `env:"platform,opt[auto,ios,macos,tvos]"`
@@ -46,33 +57,137 @@ type Config struct {
 	BundleID                 string `env:"bundle_id"`
 	BundleVersion            string `env:"bundle_version"`
 	BundleShortVersionString string `env:"bundle_short_version_string"`
+	AppIDCacheDir            string `env:"app_id_cache_dir"`
 
 	// Debug
 	IsVerbose        bool   `env:"verbose_log,opt[yes,no]"`
 	AdditionalParams string `env:"altool_options"`
 	RetryTimes       string `env:"retries"`
+	AttemptTimeout   int    `env:"attempt_timeout,required"`
+
+	// Retry policy
+	RetryBackoff           string `env:"retry_backoff,opt[fixed,exponential]"`
+	RetryMaxDelay          int    `env:"retry_max_delay,required"`
+	RetryJitter            bool   `env:"retry_jitter,opt[yes,no]"`
+	RetryDeadline          int    `env:"retry_deadline,required"`
+	RetryableErrorCodes    string `env:"retryable_error_codes"`
+	RetryableErrorPatterns string `env:"retryable_error_patterns"`
+
+	// Build processing
+	WaitForProcessing      bool `env:"wait_for_processing,opt[yes,no]"`
+	ProcessingTimeout      int  `env:"processing_timeout,required"`
+	ProcessingPollInterval int  `env:"processing_poll_interval,required"`
+
+	// TestFlight
+	BetaGroups   string `env:"beta_groups"`
+	WhatsNew     string `env:"whats_new"`
+	WhatsNewFile string `env:"whats_new_file"`
+
+	// TestFlight beta review
+	SubmitForBetaReview           bool            `env:"submit_for_beta_review,opt[yes,no]"`
+	BetaReviewContactFirstName    string          `env:"beta_review_contact_first_name"`
+	BetaReviewContactLastName     string          `env:"beta_review_contact_last_name"`
+	BetaReviewContactEmail        string          `env:"beta_review_contact_email"`
+	BetaReviewContactPhone        string          `env:"beta_review_contact_phone"`
+	BetaReviewDemoAccountName     string          `env:"beta_review_demo_account_name"`
+	BetaReviewDemoAccountPassword stepconf.Secret `env:"beta_review_demo_account_password"`
+	BetaReviewNotes               string          `env:"beta_review_notes"`
+
+	// App Store release
+	AttachToAppStoreVersion       bool   `env:"attach_to_app_store_version,opt[yes,no]"`
+	RenameEditableAppStoreVersion bool   `env:"rename_editable_app_store_version,opt[yes,no]"`
+	ReleaseType                   string `env:"release_type,opt[unchanged,manual,after_approval,scheduled]"`
+	ScheduledReleaseDate          string `env:"scheduled_release_date"`
+	PhasedRelease                 string `env:"phased_release,opt[unchanged,yes,no]"`
+	SubmitForAppReview            bool   `env:"submit_for_app_review,opt[yes,no]"`
 
 	// Used to get Bitrise Apple Developer Portal Connection
 	BuildURL      string          `env:"BITRISE_BUILD_URL"`
 	BuildAPIToken stepconf.Secret `env:"BITRISE_BUILD_API_TOKEN"`
 }
 
-func (cfg Config) validateArtifact() error {
-	cfg.IpaPath = strings.TrimSpace(cfg.IpaPath)
-	cfg.PkgPath = strings.TrimSpace(cfg.PkgPath)
+const betaReviewStateOutputKey = "APP_STORE_CONNECT_BETA_REVIEW_STATE"
 
-	var (
-		deployIPA = cfg.IpaPath != ""
-		deployPKG = cfg.PkgPath != ""
-	)
+const (
+	uploadMethodAltool = "altool"
+	uploadMethodAPI    = "app_store_connect_api"
+)
+
+const (
+	modeUpload             = "upload"
+	modeValidate           = "validate"
+	modeValidateThenUpload = "validate_then_upload"
+)
 
-	if deployIPA == deployPKG {
-		return fmt.Errorf("one artifact is required and only one is allowed, either provide ipa_path or pkg_path")
+// validateArtifacts checks the App details Inputs, which describe a single app
+func (cfg Config) validateArtifacts(artifacts []string) error {
+	if len(artifacts) > 1 && (cfg.AppID != "" || cfg.BundleID != "" || cfg.BundleVersion != "" || cfg.BundleShortVersionString != "") {
+		return fmt.Errorf("App details Inputs (app_id, bundle_id, bundle_version, bundle_short_version_string) are not supported with multiple artifacts, the details are read from each artifact")
 	}
 
 	return nil
 }
 
+// parseList splits a pipe (|) or newline separated Input value, empty items are dropped
+func parseList(value string) []string {
+	var items []string
+	for _, item := range strings.FieldsFunc(value, func(r rune) bool { return r == '|' || r == '\n' }) {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+
+	return items
+}
+
+// retryPolicy returns the upload retry policy configured by the Inputs
+func (cfg Config) retryPolicy() (retryPolicy, error) {
+	if cfg.RetryMaxDelay < 0 {
+		return retryPolicy{}, fmt.Errorf("retry_max_delay should not be negative, got: %d", cfg.RetryMaxDelay)
+	}
+	if cfg.RetryDeadline < 0 {
+		return retryPolicy{}, fmt.Errorf("retry_deadline should not be negative, got: %d", cfg.RetryDeadline)
+	}
+
+	policy := newRetryPolicy(cfg.RetryTimes)
+	policy.backoff = cfg.RetryBackoff
+	policy.maxDelay = time.Duration(cfg.RetryMaxDelay) * time.Second
+	policy.jitter = cfg.RetryJitter
+	policy.deadline = time.Duration(cfg.RetryDeadline) * time.Minute
+	policy.extraErrorCodes = parseList(cfg.RetryableErrorCodes)
+	// Patterns are newline separated only, as | is the alternation operator of regular expressions
+	for _, line := range strings.Split(cfg.RetryableErrorPatterns, "\n") {
+		if line = strings.TrimSpace(line); line == "" {
+			continue
+		}
+		pattern, err := regexp.Compile(line)
+		if err != nil {
+			return retryPolicy{}, fmt.Errorf("invalid retryable error pattern (%s): %w", line, err)
+		}
+		policy.extraPatterns = append(policy.extraPatterns, pattern)
+	}
+
+	return policy, nil
+}
+
+func (cfg Config) betaReviewDetails() betaReviewDetails {
+	details := betaReviewDetails{
+		ContactFirstName:    strings.TrimSpace(cfg.BetaReviewContactFirstName),
+		ContactLastName:     strings.TrimSpace(cfg.BetaReviewContactLastName),
+		ContactEmail:        strings.TrimSpace(cfg.BetaReviewContactEmail),
+		ContactPhone:        strings.TrimSpace(cfg.BetaReviewContactPhone),
+		DemoAccountName:     strings.TrimSpace(cfg.BetaReviewDemoAccountName),
+		DemoAccountPassword: string(cfg.BetaReviewDemoAccountPassword),
+		Notes:               cfg.BetaReviewNotes,
+	}
+	if details.DemoAccountName != "" {
+		demoAccountRequired := true
+		details.DemoAccountRequired = &demoAccountRequired
+	}
+
+	return details
+}
+
 func parseAuthSources(connection string) ([]appleauth.Source, error) {
 	switch connection {
 	case "automatic":
@@ -117,69 +232,98 @@ func handleSessionDataError(logger log.Logger, err error) {
 	logger.Warnf("Read more: https://devcenter.bitrise.io/getting-started/configuring-bitrise-steps-that-require-apple-developer-account-data/")
 }
 
-func getKeyPath(keyID string, keyPaths []string) (string, error) {
-	certName := fmt.Sprintf("AuthKey_%s.p8", keyID)
-
-	for _, path := range keyPaths {
-		certPath := filepath.Join(path, certName)
-
-		switch exists, err := pathutil.IsPathExists(certPath); {
-		case err != nil:
-			return "", err
-		case exists:
-			return certPath, os.ErrExist
-		}
+func prepareAltoolUploader(ctx context.Context, logger log.Logger, cfg Config, authConfig appleauth.Credentials, apiKeyPath string, xcodeMajorVersion int64, filePth string, packageDetails packageDetails, appID string, validate bool) (uploader, error) {
+	additionalParams, err := shellquote.Split(cfg.AdditionalParams)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse additional parameters: %w", err)
 	}
 
-	return filepath.Join(keyPaths[0], certName), nil
+	altoolCommand, envs := buildAltoolCommand(logger, filePth, packageDetails, cfg.Platform, additionalParams, authConfig, apiKeyPath, xcodeMajorVersion, appID, cfg.IsVerbose, validate)
+
+	return newAltoolUploader(ctx, logger, altoolCommand, envs, filePth, validate, time.Duration(cfg.AttemptTimeout)*time.Minute), nil
 }
 
-func writeAPIKey(privateKey, keyID string) error {
-	// see these in the altool's man page
-	var keyPaths = []string{
-		filepath.Join(os.Getenv("HOME"), ".appstoreconnect/private_keys"),
-		filepath.Join(os.Getenv("HOME"), ".private_keys"),
-		filepath.Join(os.Getenv("HOME"), "private_keys"),
-		"./private_keys",
+// prepareAPIPrivateKey writes the private key for altool and registers its removal, it returns the key's path
+func prepareAPIPrivateKey(logger log.Logger, apiKey devportalservice.APIKeyConnection, xcodeMajorVersion int64) (string, error) {
+	key, err := writeAPIPrivateKey(apiKey.PrivateKey, apiKey.KeyID)
+	if key.dir != "" {
+		addCleanup(func() {
+			if err := key.remove(); err != nil {
+				logger.Warnf("Failed to remove the API private key: %s", err)
+			}
+		})
 	}
-
-	keyPath, err := getKeyPath(keyID, keyPaths)
 	if err != nil {
-		if err == os.ErrExist {
-			return nil
-		}
-		return err
+		return "", err
 	}
 
-	if err := os.MkdirAll(filepath.Dir(keyPath), 0777); err != nil {
-		return err
+	if xcodeMajorVersion < p8FilePathMinXcodeVersion {
+		conflicting, err := conflictingAPIKeys(apiKey.PrivateKey, apiKey.KeyID, defaultAPIKeyDirs(os.Getenv("HOME")))
+		if err != nil {
+			return "", fmt.Errorf("failed to check existing private keys: %w", err)
+		}
+		for _, pth := range conflicting {
+			logger.Warnf("A different private key with the same name exists at %s, altool might use it instead of the provided key. Remove it if authentication fails.", pth)
+		}
 	}
 
-	return fileutil.WriteStringToFile(keyPath, privateKey)
+	return key.path, nil
 }
 
 func main() {
-	logger := log.NewLogger()
+	// Every log line (including the altool command, its streamed output and error messages) is redacted
+	secrets := newRedactor()
+	output := newRedactingWriter(os.Stdout, secrets)
+	logger := log.NewLogger(log.WithOutput(output))
+	// Registered first, so it runs last and writes the output of the other cleanups too
+	addCleanup(func() {
+		_ = output.flush()
+	})
+	defer runCleanups()
 	parser := metaparser.New(logger, fileutilv2.NewFileManager())
 
 	var cfg Config
 	if err := stepconf.Parse(&cfg); err != nil {
 		failf(logger, "Error: %s", err)
 	}
+	secrets.add(string(cfg.Password), string(cfg.AppSpecificPassword), string(cfg.APIKeyPath), string(cfg.BetaReviewDemoAccountPassword), string(cfg.BuildAPIToken))
 
 	stepconf.Print(cfg)
 	logger.Println()
 	logger.EnableDebugLog(cfg.IsVerbose)
 
-	if err := cfg.validateArtifact(); err != nil {
-		failf(logger, "Input error: %s", err)
-	}
-
 	cfg.AppID = strings.TrimSpace(cfg.AppID)
 	cfg.BundleID = strings.TrimSpace(cfg.BundleID)
 	cfg.BundleVersion = strings.TrimSpace(cfg.BundleVersion)
 	cfg.BundleShortVersionString = strings.TrimSpace(cfg.BundleShortVersionString)
 
+	artifacts, err := expandArtifactPaths(cfg.IpaPath, cfg.PkgPath)
+	if err != nil {
+		failf(logger, "Input error: %s", err)
+	}
+	if err := cfg.validateArtifacts(artifacts); err != nil {
+		failf(logger, "Input error: %s", err)
+	}
+	if cfg.ConcurrentUploads < 1 {
+		failf(logger, "Input error: concurrent_uploads should be at least 1, got: %d", cfg.ConcurrentUploads)
+	}
+	policy, err := cfg.retryPolicy()
+	if err != nil {
+		failf(logger, "Input error: %s", err)
+	}
+	if cfg.ProcessingTimeout < 1 {
+		failf(logger, "Input error: processing_timeout should be at least 1, got: %d", cfg.ProcessingTimeout)
+	}
+	if cfg.ProcessingPollInterval < 1 {
+		failf(logger, "Input error: processing_poll_interval should be at least 1, got: %d", cfg.ProcessingPollInterval)
+	}
+	if cfg.AttemptTimeout < 0 {
+		failf(logger, "Input error: attempt_timeout should not be negative, got: %d", cfg.AttemptTimeout)
+	}
+	if err := runCheck(logger, cfg.VerifyNestedBundles, func() error { return preflightNestedBundles(logger, artifacts) }); err != nil {
+		failf(logger, "%s", err)
+	}
+
 	authInputs := appleauth.Inputs{
 		Username:            cfg.AppleID,
 		Password:            string(cfg.Password),
@@ -191,11 +335,6 @@ func main() {
 		failf(logger, "Issue with authentication related inputs: %v", err)
 	}
 
-	xcodeVersion, err := utility.GetXcodeVersion()
-	if err != nil {
-		failf(logger, "Failed to determine Xcode version: %s", err)
-	}
-
 	// Select and fetch Apple authenication source
 	authSources, err := parseAuthSources(cfg.BitriseConnection)
 	if err != nil {
@@ -223,88 +362,195 @@ func main() {
 		}
 	}
 
-	authConfig, err := appleauth.Select(conn, authSources, authInputs)
-	if err != nil {
-		failf(logger, "Could not configure Apple Service authentication: %v", err)
+	// With auth_fallback the credentials of every source are collected, the next one is used if the previous is rejected
+	var candidates []authCandidate
+	if cfg.AuthFallback {
+		if candidates, err = collectCredentials(logger, conn, authSources, authInputs); err != nil {
+			failf(logger, "Could not configure Apple Service authentication: %v", err)
+		}
+	} else {
+		authConfig, err := appleauth.Select(conn, authSources, authInputs)
+		if err != nil {
+			failf(logger, "Could not configure Apple Service authentication: %v", err)
+		}
+		candidates = []authCandidate{{credentials: authConfig}}
 	}
-	if authConfig.AppleID != nil && authConfig.AppleID.AppSpecificPassword == "" {
-		logger.Warnf("If 2FA enabled, Application-specific password is required when using Apple ID authentication.")
+	for _, candidate := range candidates {
+		if candidate.credentials.AppleID != nil {
+			secrets.add(candidate.credentials.AppleID.Password, candidate.credentials.AppleID.AppSpecificPassword, candidate.credentials.AppleID.Session)
+		}
+		if candidate.credentials.APIKey != nil {
+			secrets.add(candidate.credentials.APIKey.PrivateKey)
+		}
 	}
 
-	// Prepare command
-	var authParams []string
-	if authConfig.APIKey != nil {
-		if err := writeAPIKey(string(authConfig.APIKey.PrivateKey), authConfig.APIKey.KeyID); err != nil {
-			failf(logger, "Failed to prepare certificate for authentication, error: %s", err)
+	// SIGINT and SIGTERM kill the running altool processes and cancel the App Store Connect API requests,
+	// the output captured until then is still reported
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// altool reports invalid credentials only after the upload, and retries them as a possibly transient error,
+	// so API keys are checked before the upload. With auth_fallback invalid credentials are skipped.
+	for candidates[0].credentials.APIKey != nil {
+		client, err := preflightAPIKey(ctx, logger, *candidates[0].credentials.APIKey, cfg.VerifyAPIKey)
+		if err == nil {
+			candidates[0].apiClient = client
+			break
 		}
-		authParams = []string{"--apiKey", authConfig.APIKey.KeyID, "--apiIssuer", authConfig.APIKey.IssuerID}
-	} else {
-		password := string(authConfig.AppleID.Password)
-		if string(authConfig.AppleID.AppSpecificPassword) != "" {
-			password = string(authConfig.AppleID.AppSpecificPassword)
+		var credentialErr actionableError
+		if !errors.As(err, &credentialErr) || len(candidates) == 1 {
+			failf(logger, "%s", err)
 		}
-		authParams = []string{"--username", authConfig.AppleID.Username, "--password", password}
+		logger.Warnf("Skipping credentials (%s): %s", candidates[0].source, err)
+		candidates = candidates[1:]
+	}
+	fallbackCandidates := candidates[:1]
+	for _, candidate := range candidates[1:] {
+		if candidate.credentials.APIKey != nil {
+			// Fallback API keys are only validated, calling App Store Connect with every key would slow down the step
+			client, err := preflightAPIKey(ctx, logger, *candidate.credentials.APIKey, false)
+			if err != nil {
+				logger.Warnf("Skipping fallback credentials (%s): %s", candidate.source, err)
+				continue
+			}
+			candidate.apiClient = client
+		}
+		fallbackCandidates = append(fallbackCandidates, candidate)
 	}
-
-	filePth := cfg.IpaPath
-	if filePth == "" {
-		filePth = cfg.PkgPath
+	candidates = fallbackCandidates
+	authConfig := candidates[0].credentials
+	if cfg.AuthFallback {
+		logger.Println()
+		logger.Infof("Credentials in fallback order:")
+		for i, candidate := range candidates {
+			logger.Printf("%d. %s", i+1, candidate.source)
+		}
 	}
-	if filePth == "" {
-		failf(logger, "Either IPA path or PKG path has to be provided")
+
+	if authConfig.AppleID != nil && authConfig.AppleID.AppSpecificPassword == "" {
+		logger.Warnf("If 2FA enabled, Application-specific password is required when using Apple ID authentication.")
 	}
 
-	additionalParams, err := shellquote.Split(cfg.AdditionalParams)
+	// API keys are required by the App Store Connect API upload and the build configuration after the upload,
+	// with auth_fallback any source may provide the key
+	hasAPIKey := slices.ContainsFunc(candidates, func(candidate authCandidate) bool { return candidate.apiClient != nil })
+	useAPI := cfg.UploadMethod == uploadMethodAPI
+	if useAPI && !hasAPIKey {
+		failf(logger, "Uploading with the App Store Connect API requires API key authentication, Apple ID is not supported.")
+	}
+	if useAPI && cfg.Mode != modeUpload {
+		failf(logger, "Input error: %s mode requires the altool upload method, the App Store Connect API does not support validation.", cfg.Mode)
+	}
+	betaGroups := parseList(cfg.BetaGroups)
+	// Notes are validated before the upload, so invalid notes do not leave a half-configured build
+	whatsNew, err := parseWhatsNew(cfg.WhatsNew, strings.TrimSpace(cfg.WhatsNewFile))
 	if err != nil {
-		failf(logger, "Failed to parse additional parameters, error: %s", err)
+		failf(logger, "Invalid What to Test notes: %s", err)
 	}
-
-	packageDetails := packageDetails{
-		bundleID:                 cfg.BundleID,
-		bundleVersion:            cfg.BundleVersion,
-		bundleShortVersionString: cfg.BundleShortVersionString,
+	if err := validateWhatsNew(whatsNew); err != nil {
+		failf(logger, "Invalid What to Test notes:\n%s", err)
+	}
+	releaseToAppStore := cfg.AttachToAppStoreVersion || cfg.SubmitForAppReview
+	releaseSettings := appStoreReleaseSettings{
+		releaseType:           cfg.ReleaseType,
+		scheduledReleaseDate:  strings.TrimSpace(cfg.ScheduledReleaseDate),
+		phasedRelease:         cfg.PhasedRelease,
+		renameEditableVersion: cfg.RenameEditableAppStoreVersion,
+	}
+	if err := releaseSettings.validate(); err != nil {
+		failf(logger, "Invalid App Store release settings: %s", err)
+	}
+	// Distributing the build requires it to be processed
+	waitForProcessing := cfg.WaitForProcessing || len(betaGroups) > 0 || len(whatsNew) > 0 || cfg.SubmitForBetaReview || releaseToAppStore
+	if waitForProcessing && cfg.Mode == modeValidate {
+		logger.Warnf("Nothing is uploaded in validate mode, build processing, TestFlight and App Store release Inputs are ignored.")
+		waitForProcessing, releaseToAppStore = false, false
+		betaGroups, whatsNew = nil, nil
+		cfg.SubmitForBetaReview = false
 	}
-	if cfg.AppID != "" {
-		// If App ID is provided, BundleID, Version and ShortVersion must be provided too, or read from the package
-		if cfg.IpaPath == "" {
-			failf(logger, "App ID not supported with PKG upload yet.")
+	if waitForProcessing && !hasAPIKey {
+		failf(logger, "Waiting for build processing and TestFlight configuration require API key authentication, Apple ID is not supported.")
+	}
+
+	var xcodeMajorVersion int64
+	if !useAPI {
+		xcodeVersion, err := utility.GetXcodeVersion()
+		if err != nil {
+			failf(logger, "Failed to determine Xcode version: %s", err)
 		}
+		xcodeMajorVersion = xcodeVersion.MajorVersion
 
-		// Every Input overrides the respective Info.plist value parsed from the IPA
-		if packageDetails.hasMissingFields() {
-			if packageDetails, err = readPackageDetails(parser, filePth, packageDetails); err != nil {
-				logger.Infof("Provide App details Inputs to skip Info.plist parsing: app_id, bundle_id, bundle_version, bundle_short_version_string.")
-				failf(logger, "Could not read App details from Info.plist: %s", err)
+		for i, candidate := range candidates {
+			if candidate.credentials.APIKey == nil {
+				continue
+			}
+			if candidates[i].apiKeyPath, err = prepareAPIPrivateKey(logger, *candidate.credentials.APIKey, xcodeMajorVersion); err != nil {
+				failf(logger, "Failed to prepare API key for authentication: %s", err)
 			}
 		}
-		if packageDetails.hasMissingFields() {
-			logger.Infof("Provide App details Inputs to skip Info.plist parsing: app_id, bundle_id, bundle_version, bundle_short_version_string.")
-			failf(logger, "Could not read all App details from Info.plist: %+v", packageDetails)
+	} else {
+		// The App Store Connect API upload authenticates with the first API key
+		candidates = slices.DeleteFunc(candidates, func(candidate authCandidate) bool { return candidate.apiClient == nil })
+		if len(candidates) > 1 {
+			logger.Warnf("Falling back to the next credentials is not supported with the App Store Connect API upload method, only %s is used.", candidates[0].source)
+			candidates = candidates[:1]
 		}
 	}
+	authChain := newAuthChain(candidates)
 
-	altoolCommand := buildAltoolCommand(logger, filePth, packageDetails, cfg.Platform, additionalParams, authParams, xcodeVersion.MajorVersion, cfg.AppID, cfg.IsVerbose)
-	errorOut, result, uploadErr := uploadWithRetry(logger, newAltoolUploader(logger, altoolCommand, filePth, authConfig), cfg.RetryTimes)
-
-	// Xcode 16 (but not Xcode 26) prints the bearer token to stderr
-	if matches := regexp.MustCompile(`(?i)"Bearer(.*?)"`).FindStringSubmatch(errorOut); len(matches) == 2 {
-		errorOut = strings.ReplaceAll(errorOut, matches[1], "[REDACTED]")
-	}
-	logger.Println()
-	logger.Printf("%s", errorOut)
-	logger.Println()
-	for _, warning := range result.getWarnings() {
-		logger.Warnf("%s", warning)
+	deployer := artifactDeployer{
+		logger: logger,
+		newLogger: func(prefix string) log.Logger {
+			return log.NewLogger(log.WithPrefix(prefix), log.WithDebugLog(cfg.IsVerbose), log.WithOutput(output))
+		},
+		newUploader: func(logger log.Logger, filePth string, packageDetails packageDetails, appID string, platform platformType, validate bool) (uploader, error) {
+			if useAPI {
+				return newAppStoreConnectUploader(ctx, logger, candidates[0].apiClient, filePth, appID, packageDetails, platform, time.Duration(cfg.ProcessingPollInterval)*time.Second, time.Duration(cfg.ProcessingTimeout)*time.Minute), nil
+			}
+			newCandidateUploader := func(candidate authCandidate) (uploader, error) {
+				return prepareAltoolUploader(ctx, logger, cfg, candidate.credentials, candidate.apiKeyPath, xcodeMajorVersion, filePth, packageDetails, appID, validate)
+			}
+			if len(candidates) > 1 {
+				return newFallbackUploader(logger, authChain, newCandidateUploader), nil
+			}
+			return newCandidateUploader(candidates[0])
+		},
+		cfg:               cfg,
+		retryPolicy:       policy,
+		parser:            parser,
+		auth:              authChain,
+		appIDCacheDir:     strings.TrimSpace(cfg.AppIDCacheDir),
+		useAPI:            useAPI,
+		waitForProcessing: waitForProcessing,
+		betaGroups:        betaGroups,
+		whatsNew:          whatsNew,
+		releaseToAppStore: releaseToAppStore,
+		releaseSettings:   releaseSettings,
+	}
+	results := deployer.deployAll(ctx, artifacts, cfg.FailurePolicy, cfg.ConcurrentUploads)
+
+	if cfg.SubmitForBetaReview {
+		var states []string
+		for _, result := range results {
+			if result.betaReviewState != "" {
+				states = append(states, result.betaReviewState)
+			}
+		}
+		if err := exportEnvironmentWithEnvman(betaReviewStateOutputKey, strings.Join(states, "|")); err != nil {
+			logger.Warnf("Failed to export %s: %s", betaReviewStateOutputKey, err)
+		}
 	}
-	logger.Println()
 
-	if uploadErr != nil {
-		failf(logger, errorutil.FormattedError(fmt.Errorf("Uploading IPA failed: %w", uploadErr)))
+	if failed := printSummary(logger, results); failed > 0 {
+		failf(logger, "Failed to deploy %d of %d artifacts", failed, len(artifacts))
 	}
-	if result.SuccessMessage != "" {
-		logger.Infof("%s", result.SuccessMessage)
-	}
-	logger.Donef("IPA uploaded")
+}
+
+func exportEnvironmentWithEnvman(key, value string) error {
+	cmd := command.New("envman", "add", "--key", key)
+	cmd.SetStdin(strings.NewReader(value))
+
+	return cmd.Run()
 }
 
 type uploader interface {
@@ -312,48 +558,65 @@ type uploader interface {
 }
 
 type altoolUploader struct {
+	ctx          context.Context
 	logger       log.Logger
+	name         string
 	altoolParams []string
-	filePth      string
-	authConfig   appleauth.Credentials
+	// envs are added to the altool process environment, they hold the secrets referenced by the parameters
+	envs     []string
+	filePth  string
+	validate bool
+	// attemptTimeout limits a single altool run, 0 means no limit
+	attemptTimeout time.Duration
 }
 
-func newAltoolUploader(logger log.Logger, altoolParams []string, filePth string, authConfig appleauth.Credentials) uploader {
-	return altoolUploader{logger: logger, altoolParams: altoolParams, filePth: filePth, authConfig: authConfig}
+func newAltoolUploader(ctx context.Context, logger log.Logger, altoolParams []string, envs []string, filePth string, validate bool, attemptTimeout time.Duration) uploader {
+	return altoolUploader{ctx: ctx, logger: logger, name: "xcrun", altoolParams: altoolParams, envs: envs, filePth: filePth, validate: validate, attemptTimeout: attemptTimeout}
 }
 
 func (a altoolUploader) upload() (string, string, altoolResult, error) {
-	cmd := command.New("xcrun", a.altoolParams...)
 	var sb bytes.Buffer
 	var eb bytes.Buffer
-	cmd.SetStdout(&sb)
-	cmd.SetStderr(&eb)
 
 	fileName := filepath.Base(a.filePth)
-	a.logger.Infof("Uploading - %s ...", fileName)
+	if a.validate {
+		a.logger.Infof("Validating - %s ...", fileName)
+	} else {
+		a.logger.Infof("Uploading - %s ...", fileName)
+	}
 
-	commandStr := cmd.PrintableCommandArgs()
-	authConfig := a.authConfig
-	if authConfig.APIKey == nil {
-		if authConfig.AppleID.Password != "" {
-			commandStr = strings.ReplaceAll(commandStr, authConfig.AppleID.Password, "[REDACTED]")
-		}
-		if authConfig.AppleID.AppSpecificPassword != "" {
-			commandStr = strings.ReplaceAll(commandStr, authConfig.AppleID.AppSpecificPassword, "[REDACTED]")
+	a.logger.Printf("$ %s", command.PrintableCommandArgs(false, append([]string{a.name}, a.altoolParams...)))
+
+	// The error output is streamed while altool runs, as large uploads take minutes,
+	// recognized progress lines are summarized in a periodic progress log instead, unrecognized lines are printed as-is
+	progress := newProgressReporter(a.logger)
+	stdoutLines := newLineWriter(func(line string) {
+		progress.handleLine(line)
+	})
+	stderrLines := newLineWriter(func(line string) {
+		if !progress.handleLine(line) {
+			a.logger.Printf("%s", line)
 		}
-	}
-	a.logger.Printf("$ %s", commandStr)
+	})
 
-	var altoolErrors []error
-	err := cmd.Run()
-	if err != nil {
-		altoolErrors = []error{err}
-	}
+	runErr := runAltool(a.ctx, a.attemptTimeout, a.envs, io.MultiWriter(&sb, stdoutLines), io.MultiWriter(&eb, stderrLines), a.name, a.altoolParams...)
+	stdoutLines.flush()
+	stderrLines.flush()
 	stdOut := sb.String()
 	errorOut := eb.String()
 
+	// The output captured before a timeout or cancellation is parsed too, for diagnostics
 	// Xcode 26RC altool always returns exit code 0, even on some failures
 	result, err := parseAltoolOutput(a.logger, stdOut, errorOut, slices.Contains(a.altoolParams, "json"))
+	if runErr != nil && (errors.Is(runErr, context.DeadlineExceeded) || errors.Is(runErr, context.Canceled)) {
+		// A timeout or cancellation is reported over the partial output's parsing error, so it is classified correctly
+		return stdOut, errorOut, result, runErr
+	}
+
+	var altoolErrors []error
+	if runErr != nil {
+		altoolErrors = []error{runErr}
+	}
 	if err != nil {
 		altoolErrors = append(altoolErrors, err)
 	}
@@ -365,25 +628,10 @@ func (a altoolUploader) upload() (string, string, altoolResult, error) {
 	return stdOut, errorOut, result, nil
 }
 
-func uploadWithRetry(logger log.Logger, uploader uploader, retryTimes string, opts ...retry.Option) (string, altoolResult, error) {
-	var retriableRegexes = []*regexp.Regexp{
-		// https://bitrise.atlassian.net/browse/STEP-1190
-		regexp.MustCompile(`(?s).*Unable to determine the application using bundleId.*-19201.*`),
-		regexp.MustCompile(`(?s).*Unable to determine app platform for 'Undefined' software type.*1194.*`),
-		regexp.MustCompile(`(?s).*TransporterService.*error occurred trying to read the bundle.*-18000.*`),
-		regexp.MustCompile(`(?s).*Unable to authenticate.*-19209.*`),
-		regexp.MustCompile(`(?s).*server returned an invalid response.*try your request again.*`),
-		regexp.MustCompile(`(?s).*The request timed out.*`),
-	}
-
-	parsedRetryTimes, err := strconv.ParseInt(retryTimes, 10, 32)
-	attempts := uint(parsedRetryTimes)
-	if err != nil {
-		attempts = uint(10)
-	}
-	mOpts := []retry.Option{
-		retry.Attempts(attempts),
-		retry.Delay(300 * time.Millisecond),
+func uploadWithRetry(logger log.Logger, uploader uploader, policy retryPolicy, opts ...retry.Option) (string, altoolResult, error) {
+	start := time.Now()
+	attempts := policy.attempts
+	mOpts := append(policy.options(start),
 		retry.LastErrorOnly(true),
 		retry.OnRetry(func(n uint, err error) {
 			if n == 0 {
@@ -394,27 +642,33 @@ func uploadWithRetry(logger log.Logger, uploader uploader, retryTimes string, op
 				logger.Warnf("Attempt %d failed", attempts)
 			}
 		}),
-	}
+	)
 
 	mOpts = append(mOpts, opts...)
 
 	var errorOut string
 	var result altoolResult
-	err = retry.Do(
+	err := retry.Do(
 		func() error {
 			var err error
 			var stdOut string
 			stdOut, errorOut, result, err = uploader.upload()
 			logger.Debugf("%s", stdOut) // JSON output is only visible in debug mode
 			if err != nil {
-				for _, re := range retriableRegexes {
-					matched := re.MatchString(errorOut)
-					if matched {
-						logger.Printf("Upload error, checking retries: %s\n", err)
-						return err
-					}
+				decision := policy.classify(err, result, errorOut)
+				if !decision.retryable {
+					logger.Printf("Upload error is not retryable (%s)", decision.reason)
+					return retry.Unrecoverable(err)
 				}
-				return retry.Unrecoverable(err)
+				if elapsed := time.Since(start); policy.deadlineExceeded(elapsed) {
+					logger.Printf("Upload error is retryable (%s), but the retry deadline (%s) is exceeded", decision.reason, policy.deadline)
+					return retry.Unrecoverable(err)
+				}
+				logger.Printf("Upload error is retryable (%s), checking retries: %s", decision.reason, err)
+				if decision.rateLimited {
+					return rateLimitedError{err: err, retryAfter: decision.retryAfter}
+				}
+				return err
 			}
 
 			return nil
@@ -424,7 +678,22 @@ func uploadWithRetry(logger log.Logger, uploader uploader, retryTimes string, op
 	return errorOut, result, err
 }
 
+// cleanups are run before the step exits, failf runs them too, as deferred functions do not run on os.Exit
+var cleanups []func()
+
+func addCleanup(cleanup func()) {
+	cleanups = append(cleanups, cleanup)
+}
+
+func runCleanups() {
+	for i := len(cleanups) - 1; i >= 0; i-- {
+		cleanups[i]()
+	}
+	cleanups = nil
+}
+
 func failf(logger log.Logger, format string, v ...interface{}) {
 	logger.Errorf(format, v...)
+	runCleanups()
 	os.Exit(1)
 }
@@ -2,12 +2,10 @@ package main
 
 import (
 	"errors"
-	"os"
-	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/avast/retry-go/v4"
-	"github.com/bitrise-io/go-utils/pathutil"
 	"github.com/bitrise-io/go-utils/v2/log"
 	"github.com/stretchr/testify/assert"
 )
@@ -50,65 +48,10 @@ const undefinedSoftwareType = `{
 Non-localized server string received: 'Unable to determine app platform for 'Undefined' software type.'.
 Non-localized server string received: 'Unable to determine app platform for 'Undefined' software type. (1194)'.`
 
-func Test_getKeyPath(t *testing.T) {
-	tmpPath, err := pathutil.NormalizedOSTempDirPath("testing")
-	if err != nil {
-		t.Fatal(err)
-	}
-
-	tmpKeyPaths := []string{
-		filepath.Join(tmpPath, "test2"),
-		filepath.Join(tmpPath, "test1"),
-		filepath.Join(tmpPath, "test3"),
-	}
-
-	tmpKeyPaths2 := []string{
-		filepath.Join(tmpPath, "test22"),
-		filepath.Join(tmpPath, "test12"),
-		filepath.Join(tmpPath, "test32"),
-	}
-
-	if err := os.MkdirAll(tmpKeyPaths2[2], 0777); err != nil {
-		t.Fatal(err)
-	}
-
-	if err := os.WriteFile(filepath.Join(tmpKeyPaths2[2], "AuthKey_MyGreatID.p8"), []byte("content"), 0777); err != nil {
-		t.Fatal(err)
-	}
-
-	tests := []struct {
-		name            string
-		keyID           string
-		keyPaths        []string
-		want            string
-		wantErr         bool
-		wantOsExistsErr bool
-	}{
-		{name: "check nonexisting", keyID: "MyID", keyPaths: tmpKeyPaths, want: filepath.Join(tmpKeyPaths[0], "AuthKey_MyID.p8"), wantErr: false, wantOsExistsErr: false},
-		{name: "check existing", keyID: "MyGreatID", keyPaths: tmpKeyPaths2, want: filepath.Join(tmpKeyPaths2[2], "AuthKey_MyGreatID.p8"), wantErr: false, wantOsExistsErr: true},
-	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			got, err := getKeyPath(tt.keyID, tt.keyPaths)
-			if tt.wantOsExistsErr && (err != os.ErrExist) {
-				t.Errorf("not os.ErrExists")
-				return
-			}
-			if !tt.wantOsExistsErr && (err != nil) != tt.wantErr {
-				t.Errorf("getKeyPath() error = %v, wantErr %v", err, tt.wantErr)
-				return
-			}
-			if got != tt.want {
-				t.Errorf("getKeyPath() = %v, want %v", got, tt.want)
-			}
-		})
-	}
-}
-
 func Test_uploadSuccessful(t *testing.T) {
 	uploader := createUploaderWithSuccess()
 
-	out, res, err := uploadWithRetry(log.NewLogger(), uploader, "10")
+	out, res, err := uploadWithRetry(log.NewLogger(), uploader, newRetryPolicy("10"))
 
 	assert.NoError(t, err)
 	assert.Equal(t, out, "done")
@@ -119,7 +62,7 @@ func Test_uploadSuccessful(t *testing.T) {
 func Test_uploadFailsWithUnknownError(t *testing.T) {
 	uploader := createUploaderWithUnknownError()
 
-	_, res, err := uploadWithRetry(log.NewLogger(), uploader, "10")
+	_, res, err := uploadWithRetry(log.NewLogger(), uploader, newRetryPolicy("10"))
 
 	assert.Error(t, err)
 	assert.Equal(t, res, altoolResult{})
@@ -129,7 +72,7 @@ func Test_uploadFailsWithUnknownError(t *testing.T) {
 func Test_uploadRetriesOnUnableToDetermine(t *testing.T) {
 	uploader := createUploaderWithUnableToDetermineError()
 
-	_, res, err := uploadWithRetry(log.NewLogger(), uploader, "10", retry.Delay(0))
+	_, res, err := uploadWithRetry(log.NewLogger(), uploader, newRetryPolicy("10"), retry.Delay(0))
 
 	assert.Error(t, err)
 	assert.Equal(t, res, altoolResult{})
@@ -139,7 +82,7 @@ func Test_uploadRetriesOnUnableToDetermine(t *testing.T) {
 func Test_uploadRetriesOnTransporterService(t *testing.T) {
 	uploader := createUploaderWithTransporterService()
 
-	_, res, err := uploadWithRetry(log.NewLogger(), uploader, "10", retry.Delay(0))
+	_, res, err := uploadWithRetry(log.NewLogger(), uploader, newRetryPolicy("10"), retry.Delay(0))
 
 	assert.Error(t, err)
 	assert.Equal(t, res, altoolResult{})
@@ -149,7 +92,7 @@ func Test_uploadRetriesOnTransporterService(t *testing.T) {
 func Test_uploadRetriesOnInvalidResponse(t *testing.T) {
 	uploader := createUploaderWithInvalidResponse()
 
-	_, res, err := uploadWithRetry(log.NewLogger(), uploader, "10", retry.Delay(0))
+	_, res, err := uploadWithRetry(log.NewLogger(), uploader, newRetryPolicy("10"), retry.Delay(0))
 
 	assert.Error(t, err)
 	assert.Equal(t, res, altoolResult{})
@@ -159,7 +102,7 @@ func Test_uploadRetriesOnInvalidResponse(t *testing.T) {
 func Test_uploadRetriesOnUnableToAuthenticateResponse(t *testing.T) {
 	uploader := createUploaderWithUnableToAuthenticateResponse()
 
-	_, res, err := uploadWithRetry(log.NewLogger(), uploader, "10", retry.Delay(0))
+	_, res, err := uploadWithRetry(log.NewLogger(), uploader, newRetryPolicy("10"), retry.Delay(0))
 
 	assert.Error(t, err)
 	assert.Equal(t, res, altoolResult{})
@@ -169,7 +112,7 @@ func Test_uploadRetriesOnUnableToAuthenticateResponse(t *testing.T) {
 func Test_uploadRetriesSpecificTimes(t *testing.T) {
 	uploader := createUploaderWithUnableToAuthenticateResponse()
 
-	_, res, err := uploadWithRetry(log.NewLogger(), uploader, "5", retry.Delay(0))
+	_, res, err := uploadWithRetry(log.NewLogger(), uploader, newRetryPolicy("5"), retry.Delay(0))
 
 	assert.Error(t, err)
 	assert.Equal(t, res, altoolResult{})
@@ -179,7 +122,7 @@ func Test_uploadRetriesSpecificTimes(t *testing.T) {
 func Test_uploadRetriesDefaultTimes(t *testing.T) {
 	uploader := createUploaderWithUnableToAuthenticateResponse()
 
-	_, res, err := uploadWithRetry(log.NewLogger(), uploader, "", retry.Delay(0))
+	_, res, err := uploadWithRetry(log.NewLogger(), uploader, newRetryPolicy(""), retry.Delay(0))
 
 	assert.Error(t, err)
 	assert.Equal(t, res, altoolResult{})
@@ -189,7 +132,7 @@ func Test_uploadRetriesDefaultTimes(t *testing.T) {
 func Test_uploadRetriesOnRequestTimedOutResponse(t *testing.T) {
 	uploader := createUploaderWithRequestTimedOutResponse()
 
-	_, res, err := uploadWithRetry(log.NewLogger(), uploader, "10", retry.Delay(0))
+	_, res, err := uploadWithRetry(log.NewLogger(), uploader, newRetryPolicy("10"), retry.Delay(0))
 
 	assert.Error(t, err)
 	assert.Equal(t, res, altoolResult{})
@@ -199,7 +142,7 @@ func Test_uploadRetriesOnRequestTimedOutResponse(t *testing.T) {
 func Test_uploadRecoversAfterErrorOnValidResponse(t *testing.T) {
 	uploader := createUploaderWithFailingAndRecoveringResponse()
 
-	out, res, err := uploadWithRetry(log.NewLogger(), uploader, "10", retry.Delay(0))
+	out, res, err := uploadWithRetry(log.NewLogger(), uploader, newRetryPolicy("10"), retry.Delay(0))
 
 	assert.NoError(t, err)
 	assert.Equal(t, out, "success")
@@ -210,7 +153,7 @@ func Test_uploadRecoversAfterErrorOnValidResponse(t *testing.T) {
 func Test_uploadRecoversAfterUndefinedSoftwareType(t *testing.T) {
 	uploader := createUploaderWithUndefinedSoftwareType()
 
-	out, res, err := uploadWithRetry(log.NewLogger(), uploader, "10", retry.Delay(0))
+	out, res, err := uploadWithRetry(log.NewLogger(), uploader, newRetryPolicy("10"), retry.Delay(0))
 
 	assert.NoError(t, err)
 	assert.Equal(t, out, "success")
@@ -218,6 +161,61 @@ func Test_uploadRecoversAfterUndefinedSoftwareType(t *testing.T) {
 	uploader.AssertNumberOfCalls(t, "upload", 2)
 }
 
+func Test_uploadRetriesOnProductErrorCode(t *testing.T) {
+	result := altoolResult{ProductErrors: []productError{{Code: -19209, UserInfo: userInfo{NSLocalizedDescription: "Unable to authenticate."}}}}
+	uploader := new(mockUploader)
+	uploader.On("upload").Return("", "", result, errors.New("test-error")).Once()
+	uploader.On("upload").Return("", "", altoolResult{SuccessMessage: "Upload done"}, nil)
+
+	_, res, err := uploadWithRetry(log.NewLogger(), uploader, newRetryPolicy("10"), retry.Delay(0))
+
+	assert.NoError(t, err)
+	assert.Equal(t, res, altoolResult{SuccessMessage: "Upload done"})
+	uploader.AssertNumberOfCalls(t, "upload", 2)
+}
+
+func Test_uploadFailsOnPermanentProductError(t *testing.T) {
+	result := altoolResult{ProductErrors: []productError{{Code: -19232, UserInfo: userInfo{IrisCode: "ENTITY_ERROR.ATTRIBUTE.INVALID.DUPLICATE"}}}}
+	uploader := new(mockUploader)
+	uploader.On("upload").Return("", "", result, errors.New("test-error"))
+
+	_, _, err := uploadWithRetry(log.NewLogger(), uploader, newRetryPolicy("10"), retry.Delay(0))
+
+	assert.Error(t, err)
+	uploader.AssertNumberOfCalls(t, "upload", 1)
+}
+
+func Test_parseList(t *testing.T) {
+	assert.Equal(t, []string(nil), parseList(""))
+	assert.Equal(t, []string{"Internal Testers", "External"}, parseList(" Internal Testers |External|"))
+	assert.Equal(t, []string{"a", "b", "c"}, parseList("a\nb|c\n\n"))
+}
+
+func TestConfig_retryPolicy(t *testing.T) {
+	cfg := Config{
+		RetryTimes:             "5",
+		RetryBackoff:           retryBackoffExponential,
+		RetryMaxDelay:          30,
+		RetryJitter:            true,
+		RetryDeadline:          20,
+		RetryableErrorCodes:    "-19208|STATE_ERROR.VALIDATION_ERROR",
+		RetryableErrorPatterns: "Service (Unavailable|Busy)\n\n",
+	}
+
+	policy, err := cfg.retryPolicy()
+
+	assert.NoError(t, err)
+	assert.Equal(t, uint(5), policy.attempts)
+	assert.Equal(t, 30*time.Second, policy.maxDelay)
+	assert.Equal(t, 20*time.Minute, policy.deadline)
+	assert.Equal(t, []string{"-19208", "STATE_ERROR.VALIDATION_ERROR"}, policy.extraErrorCodes)
+	assert.Len(t, policy.extraPatterns, 1)
+
+	cfg.RetryableErrorPatterns = "Service (Unavailable"
+	_, err = cfg.retryPolicy()
+	assert.Error(t, err)
+}
+
 func createUploaderWithUnknownError() (uploader *mockUploader) {
 	uploader = new(mockUploader)
 	uploader.On("upload").Return("", "unknown-error", altoolResult{}, errors.New("test-error"))
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/bitrise-io/go-utils/v2/log"
+)
+
+// nestedBundleViolation is an Info.plist value of a nested bundle, which App Store Connect rejects
+type nestedBundleViolation struct {
+	artifact string
+	bundle   string
+	key      string
+	value    string
+	expected string
+}
+
+// checkNestedBundles compares the Info.plist of every app extension, App Clip and watch app with its host bundle:
+// the versions have to match the app's, the bundle ID has to be prefixed by the host's bundle ID,
+// and the bundle can not require a newer OS than its host
+func checkNestedBundles(pth string) ([]nestedBundleViolation, int, error) {
+	archive, err := openIPA(pth)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer func() {
+		_ = archive.close()
+	}()
+
+	bundles, err := archive.bundles()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	app := bundles[0]
+	bundlesByPath := map[string]ipaBundle{}
+	for _, bundle := range bundles {
+		bundlesByPath[bundle.path] = bundle
+	}
+
+	var violations []nestedBundleViolation
+	for _, bundle := range bundles[1:] {
+		violation := func(key, value, expected string) {
+			violations = append(violations, nestedBundleViolation{
+				artifact: filepath.Base(pth),
+				bundle:   strings.TrimPrefix(bundle.path, path.Dir(app.path)+"/"),
+				key:      key,
+				value:    value,
+				expected: expected,
+			})
+		}
+		// The host is the bundle of the container directory (PlugIns, Watch, ...) holding the bundle
+		host := bundlesByPath[path.Dir(path.Dir(bundle.path))]
+
+		for _, key := range []string{"CFBundleVersion", "CFBundleShortVersionString"} {
+			value, _ := bundle.infoPlist.GetString(key)
+			expected, _ := app.infoPlist.GetString(key)
+			if value != expected {
+				violation(key, value, expected)
+			}
+		}
+
+		if bundleID, hostBundleID := bundle.bundleID(), host.bundleID(); !strings.HasPrefix(bundleID, hostBundleID+".") {
+			violation("CFBundleIdentifier", bundleID, hostBundleID+".*")
+		}
+
+		// Watch apps have a watchOS deployment target, only bundles of the same platform are comparable
+		platform, _ := bundle.infoPlist.GetString("DTPlatformName")
+		hostPlatform, _ := host.infoPlist.GetString("DTPlatformName")
+		minimumOS, _ := bundle.infoPlist.GetString("MinimumOSVersion")
+		hostMinimumOS, _ := host.infoPlist.GetString("MinimumOSVersion")
+		if platform == hostPlatform && minimumOS != "" && hostMinimumOS != "" && compareOSVersions(minimumOS, hostMinimumOS) > 0 {
+			violation("MinimumOSVersion", minimumOS, "<= "+hostMinimumOS)
+		}
+	}
+
+	return violations, len(bundles) - 1, nil
+}
+
+// compareOSVersions compares dot separated versions (for example 15.0 and 15.0.1), missing components are 0,
+// versions which are not numeric are treated as equal
+func compareOSVersions(a, b string) int {
+	partsA, partsB := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(partsA) || i < len(partsB); i++ {
+		var x, y int
+		var err error
+		if i < len(partsA) {
+			if x, err = strconv.Atoi(partsA[i]); err != nil {
+				return 0
+			}
+		}
+		if i < len(partsB) {
+			if y, err = strconv.Atoi(partsB[i]); err != nil {
+				return 0
+			}
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+
+	return 0
+}
+
+// preflightNestedBundles checks the nested bundles of every IPA, and prints all violations in one table
+// before any upload starts
+func preflightNestedBundles(logger log.Logger, artifacts []string) error {
+	var ipas []string
+	for _, artifact := range artifacts {
+		if filepath.Ext(artifact) == ".ipa" {
+			ipas = append(ipas, artifact)
+		}
+	}
+	if len(ipas) == 0 {
+		return nil
+	}
+
+	logger.Println()
+	logger.Infof("Checking nested bundles")
+
+	var violations []nestedBundleViolation
+	nestedBundles := 0
+	for _, pth := range ipas {
+		ipaViolations, count, err := checkNestedBundles(pth)
+		if err != nil {
+			return fmt.Errorf("Nested bundle check failed: %s: %w", filepath.Base(pth), err)
+		}
+		violations = append(violations, ipaViolations...)
+		nestedBundles += count
+	}
+
+	if len(violations) == 0 {
+		logger.Donef("%d nested bundles match their host apps", nestedBundles)
+		return nil
+	}
+
+	var table bytes.Buffer
+	w := tabwriter.NewWriter(&table, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "IPA\tBundle\tKey\tValue\tExpected")
+	for _, v := range violations {
+		value := v.value
+		if value == "" {
+			value = "(missing)"
+		}
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", v.artifact, v.bundle, v.key, value, v.expected)
+	}
+	_ = w.Flush()
+	for _, line := range strings.Split(strings.TrimSuffix(table.String(), "\n"), "\n") {
+		logger.Errorf("%s", line)
+	}
+
+	return fmt.Errorf("Nested bundle check failed: %d violations, App Store Connect rejects app extensions, App Clips and watch apps with versions, bundle IDs or deployment targets not matching their host app", len(violations))
+}
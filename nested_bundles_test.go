@@ -0,0 +1,138 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/bitrise-io/go-utils/v2/log"
+	"github.com/stretchr/testify/require"
+)
+
+func testBundleInfoPlist(bundleID, version, platform, minimumOS string) []byte {
+	return []byte(testPlist(map[string]interface{}{
+		"CFBundleIdentifier":         bundleID,
+		"CFBundleVersion":            version,
+		"CFBundleShortVersionString": "1.0",
+		"DTPlatformName":             platform,
+		"MinimumOSVersion":           minimumOS,
+	}))
+}
+
+func Test_checkNestedBundles(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string][]byte
+		want  []nestedBundleViolation
+	}{
+		{
+			name: "Consistent bundles",
+			files: map[string][]byte{
+				"Info.plist":                                            testBundleInfoPlist("com.example.app", "42", "iphoneos", "15.0"),
+				"PlugIns/Widget.appex/Info.plist":                       testBundleInfoPlist("com.example.app.widget", "42", "iphoneos", "15.0"),
+				"AppClips/Clip.app/Info.plist":                          testBundleInfoPlist("com.example.app.clip", "42", "iphoneos", "15"),
+				"Watch/Watch.app/Info.plist":                            testBundleInfoPlist("com.example.app.watch", "42", "watchos", "8.0"),
+				"Watch/Watch.app/PlugIns/Complication.appex/Info.plist": testBundleInfoPlist("com.example.app.watch.complication", "42", "watchos", "8.0"),
+			},
+		},
+		{
+			name: "Violations",
+			files: map[string][]byte{
+				"Info.plist":                                            testBundleInfoPlist("com.example.app", "42", "iphoneos", "15.0"),
+				"PlugIns/Widget.appex/Info.plist":                       testBundleInfoPlist("com.example.widget", "41", "iphoneos", "16.0"),
+				"Watch/Watch.app/Info.plist":                            testBundleInfoPlist("com.example.app.watch", "42", "watchos", "8.0"),
+				"Watch/Watch.app/PlugIns/Complication.appex/Info.plist": testBundleInfoPlist("com.example.app.complication", "42", "watchos", "8.0"),
+			},
+			want: []nestedBundleViolation{
+				{artifact: "Example.ipa", bundle: "Example.app/PlugIns/Widget.appex", key: "CFBundleVersion", value: "41", expected: "42"},
+				{artifact: "Example.ipa", bundle: "Example.app/PlugIns/Widget.appex", key: "CFBundleIdentifier", value: "com.example.widget", expected: "com.example.app.*"},
+				{artifact: "Example.ipa", bundle: "Example.app/PlugIns/Widget.appex", key: "MinimumOSVersion", value: "16.0", expected: "<= 15.0"},
+				{artifact: "Example.ipa", bundle: "Example.app/Watch/Watch.app/PlugIns/Complication.appex", key: "CFBundleIdentifier", value: "com.example.app.complication", expected: "com.example.app.watch.*"},
+			},
+		},
+		{
+			name: "Extension with a newer deployment target",
+			files: map[string][]byte{
+				"Info.plist":                      testBundleInfoPlist("com.example.app", "42", "iphoneos", "15.0"),
+				"PlugIns/Widget.appex/Info.plist": testBundleInfoPlist("com.example.app.widget", "42", "iphoneos", "15.1"),
+			},
+			want: []nestedBundleViolation{
+				{artifact: "Example.ipa", bundle: "Example.app/PlugIns/Widget.appex", key: "MinimumOSVersion", value: "15.1", expected: "<= 15.0"},
+			},
+		},
+		{
+			name: "Bundles outside of the containers",
+			files: map[string][]byte{
+				"Info.plist": testBundleInfoPlist("com.example.app", "42", "iphoneos", "15.0"),
+				"Frameworks/Example.framework/Info.plist": testBundleInfoPlist("com.example.framework", "1", "iphoneos", "12.0"),
+				"Resources/Other.appex/Info.plist":        testBundleInfoPlist("com.other", "1", "iphoneos", "17.0"),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			violations, _, err := checkNestedBundles(writeTestIPA(t, tt.files))
+
+			require.NoError(t, err)
+			require.Equal(t, tt.want, violations)
+		})
+	}
+}
+
+func Test_compareOSVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{a: "15.0", b: "15.0", want: 0},
+		{a: "15", b: "15.0.0", want: 0},
+		{a: "15.0.1", b: "15.0", want: 1},
+		{a: "14.5", b: "15.0", want: -1},
+		{a: "16.0", b: "9.3", want: 1},
+		{a: "beta", b: "15.0", want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.a+" "+tt.b, func(t *testing.T) {
+			require.Equal(t, tt.want, compareOSVersions(tt.a, tt.b))
+		})
+	}
+}
+
+func Test_preflightNestedBundles(t *testing.T) {
+	consistent := writeTestIPA(t, map[string][]byte{
+		"PlugIns/Widget.appex/Info.plist": testBundleInfoPlist("com.example.app.widget", "42", "iphoneos", ""),
+	})
+	inconsistent := writeTestIPA(t, map[string][]byte{
+		"PlugIns/Widget.appex/Info.plist": testBundleInfoPlist("com.example.app.widget", "41", "iphoneos", ""),
+		"PlugIns/Share.appex/Info.plist":  testBundleInfoPlist("com.example.share", "42", "iphoneos", ""),
+	})
+
+	tests := []struct {
+		name      string
+		artifacts []string
+		wantErr   string
+	}{
+		{name: "Consistent IPA", artifacts: []string{consistent}},
+		{name: "PKG only", artifacts: []string{"app.pkg"}},
+		{
+			name:      "Inconsistent IPA",
+			artifacts: []string{consistent, inconsistent, "app.pkg"},
+			wantErr:   "Nested bundle check failed: 2 violations",
+		},
+		{
+			name:      "Missing IPA",
+			artifacts: []string{"missing.ipa"},
+			wantErr:   "Nested bundle check failed: missing.ipa: failed to open IPA",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := preflightNestedBundles(log.NewLogger(), tt.artifacts)
+
+			if tt.wantErr != "" {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
@@ -79,6 +79,15 @@ func getPlatformType(logger log.Logger, filePath, platform string) platformType
 }
 
 func readPackageDetails(parser *metaparser.Parser, packagePath string, appInfo packageDetails) (packageDetails, error) {
+	if filepath.Ext(packagePath) == ".pkg" {
+		details, err := readPKGDetails(packagePath)
+		if err != nil {
+			return packageDetails{}, err
+		}
+		// Every Input overrides the respective value of the package
+		return appInfo.withDefaults(details), nil
+	}
+
 	info, err := parser.ParseIPAData(packagePath)
 	if err != nil {
 		return packageDetails{}, fmt.Errorf("failed to parse archive: %w", err)
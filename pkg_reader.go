@@ -0,0 +1,305 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/bitrise-io/go-xcode/plistutil"
+)
+
+const (
+	// pkgMetadataMaxSize limits the size of the Distribution, PackageInfo and Info.plist files read into memory
+	pkgMetadataMaxSize = 1 << 20
+
+	cpioODCMagic     = "070707"
+	cpioNewcMagic    = "070701"
+	cpioNewcCRCMagic = "070702"
+	cpioTrailer      = "TRAILER!!!"
+)
+
+// distribution is the Distribution file of a product archive (productbuild), it references the component packages
+//
+//	<pkg-ref id="com.example.app" version="1.0" onConclusion="none">#Example.pkg</pkg-ref>
+type distribution struct {
+	PkgRefs []struct {
+		ID   string `xml:"id,attr"`
+		Path string `xml:",chardata"`
+	} `xml:"pkg-ref"`
+}
+
+// packageInfo is the PackageInfo file of a component package (pkgbuild), it lists the bundles of the payload
+//
+//	<bundle path="./Example.app" id="com.example.app" CFBundleShortVersionString="1.0" CFBundleVersion="42"/>
+type packageInfo struct {
+	Bundles []packageInfoBundle `xml:"bundle"`
+}
+
+type packageInfoBundle struct {
+	Path         string `xml:"path,attr"`
+	ID           string `xml:"id,attr"`
+	Version      string `xml:"CFBundleVersion,attr"`
+	ShortVersion string `xml:"CFBundleShortVersionString,attr"`
+}
+
+// readPKGDetails returns the App details of the app bundle in an installer package, read from the app's Info.plist
+// in the payload. The bundle attributes of PackageInfo are used if the payload can not be read.
+func readPKGDetails(pth string) (packageDetails, error) {
+	archive, err := openXar(pth)
+	if err != nil {
+		return packageDetails{}, fmt.Errorf("failed to open package: %w", err)
+	}
+	defer func() {
+		_ = archive.close()
+	}()
+
+	components, err := componentPackages(archive)
+	if err != nil {
+		return packageDetails{}, err
+	}
+
+	for _, component := range components {
+		content, err := archive.readAll(path.Join(component, "PackageInfo"), pkgMetadataMaxSize)
+		if err != nil {
+			return packageDetails{}, err
+		}
+		var info packageInfo
+		if err := xml.Unmarshal(content, &info); err != nil {
+			return packageDetails{}, fmt.Errorf("failed to parse PackageInfo: %w", err)
+		}
+
+		bundle, ok := info.appBundle()
+		if !ok {
+			continue
+		}
+		fromPackageInfo := packageDetails{
+			bundleID:                 bundle.ID,
+			bundleVersion:            bundle.Version,
+			bundleShortVersionString: bundle.ShortVersion,
+		}
+
+		details, err := readPayloadAppDetails(archive, path.Join(component, "Payload"), bundle.Path)
+		if err != nil {
+			if fromPackageInfo.hasMissingFields() {
+				return packageDetails{}, err
+			}
+			return fromPackageInfo, nil
+		}
+
+		return details.withDefaults(fromPackageInfo), nil
+	}
+
+	return packageDetails{}, errors.New("no app bundle found in the package")
+}
+
+// componentPackages returns the directories of the component packages in the archive,
+// a component package (without Distribution) is its own component, at the root of the archive.
+func componentPackages(archive *xarArchive) ([]string, error) {
+	if !archive.has("Distribution") {
+		return []string{""}, nil
+	}
+
+	content, err := archive.readAll("Distribution", pkgMetadataMaxSize)
+	if err != nil {
+		return nil, err
+	}
+	var dist distribution
+	if err := xml.Unmarshal(content, &dist); err != nil {
+		return nil, fmt.Errorf("failed to parse Distribution: %w", err)
+	}
+
+	var components []string
+	seen := map[string]bool{}
+	for _, ref := range dist.PkgRefs {
+		// Only the references with content point to a package, the # prefix means it is inside the archive
+		ref.Path = strings.TrimSpace(ref.Path)
+		if !strings.HasPrefix(ref.Path, "#") {
+			continue
+		}
+		component, err := url.PathUnescape(strings.TrimPrefix(ref.Path, "#"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid package reference (%s): %w", ref.Path, err)
+		}
+		if seen[component] || !archive.has(path.Join(component, "PackageInfo")) {
+			continue
+		}
+		seen[component] = true
+		components = append(components, component)
+	}
+	if len(components) == 0 {
+		return nil, errors.New("no component package found in the Distribution")
+	}
+
+	return components, nil
+}
+
+// appBundle returns the first top level app bundle, nested bundles (frameworks, plug-ins) are listed inside it
+func (i packageInfo) appBundle() (packageInfoBundle, bool) {
+	for _, bundle := range i.Bundles {
+		if path.Ext(bundle.Path) == ".app" {
+			return bundle, true
+		}
+	}
+
+	return packageInfoBundle{}, false
+}
+
+func (p packageDetails) withDefaults(defaults packageDetails) packageDetails {
+	if p.bundleID == "" {
+		p.bundleID = defaults.bundleID
+	}
+	if p.bundleVersion == "" {
+		p.bundleVersion = defaults.bundleVersion
+	}
+	if p.bundleShortVersionString == "" {
+		p.bundleShortVersionString = defaults.bundleShortVersionString
+	}
+
+	return p
+}
+
+// readPayloadAppDetails reads the app's Info.plist from the payload, a gzip compressed or plain cpio archive
+func readPayloadAppDetails(archive *xarArchive, payloadPth, bundlePth string) (packageDetails, error) {
+	payload, err := archive.open(payloadPth)
+	if err != nil {
+		return packageDetails{}, err
+	}
+
+	r := bufio.NewReader(payload)
+	magic, err := r.Peek(6)
+	if err != nil {
+		return packageDetails{}, fmt.Errorf("failed to read payload: %w", err)
+	}
+	var cpio io.Reader = r
+	switch {
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		if cpio, err = gzip.NewReader(r); err != nil {
+			return packageDetails{}, fmt.Errorf("failed to read payload: %w", err)
+		}
+	case bytes.HasPrefix(magic, []byte("pbzx")):
+		return packageDetails{}, errors.New("unsupported payload format: pbzx")
+	case !bytes.HasPrefix(magic, []byte("0707")):
+		return packageDetails{}, errors.New("unsupported payload format")
+	}
+
+	infoPlistPth := path.Join(bundlePth, "Contents", "Info.plist")
+	content, err := findCPIOFile(cpio, infoPlistPth, pkgMetadataMaxSize)
+	if err != nil {
+		return packageDetails{}, err
+	}
+
+	plist, err := plistutil.NewPlistDataFromContent(string(content))
+	if err != nil {
+		return packageDetails{}, fmt.Errorf("failed to parse %s: %w", infoPlistPth, err)
+	}
+	var details packageDetails
+	details.bundleID, _ = plist.GetString("CFBundleIdentifier")
+	details.bundleVersion, _ = plist.GetString("CFBundleVersion")
+	details.bundleShortVersionString, _ = plist.GetString("CFBundleShortVersionString")
+
+	return details, nil
+}
+
+// findCPIOFile returns the content of the file from a cpio archive, in the odc (pkgbuild's default) or newc format
+func findCPIOFile(r io.Reader, name string, limit int64) ([]byte, error) {
+	name = path.Clean(name)
+	for {
+		entryName, size, padding, err := readCPIOHeader(r)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read payload: %w", err)
+		}
+		if size < 0 {
+			return nil, fmt.Errorf("invalid cpio entry size: %d", size)
+		}
+		if entryName == cpioTrailer {
+			return nil, fmt.Errorf("%s not found in the payload", name)
+		}
+
+		if path.Clean(entryName) == name {
+			if size > limit {
+				return nil, fmt.Errorf("%s is larger than %d bytes", name, limit)
+			}
+			content := make([]byte, size)
+			if _, err := io.ReadFull(r, content); err != nil {
+				return nil, fmt.Errorf("failed to read %s: %w", name, err)
+			}
+			return content, nil
+		}
+
+		if _, err := io.CopyN(io.Discard, r, size+padding(size)); err != nil {
+			return nil, fmt.Errorf("failed to read payload: %w", err)
+		}
+	}
+}
+
+// readCPIOHeader reads the header and the name of the next entry, it returns the entry's name, its data size
+// and the padding function of its data
+func readCPIOHeader(r io.Reader) (string, int64, func(int64) int64, error) {
+	magic := make([]byte, 6)
+	if _, err := io.ReadFull(r, magic); err != nil {
+		return "", 0, nil, err
+	}
+
+	switch string(magic) {
+	case cpioODCMagic:
+		// dev, ino, mode, uid, gid, nlink, rdev (6 octal digits each), mtime (11), namesize (6), filesize (11)
+		header := make([]byte, 70)
+		if _, err := io.ReadFull(r, header); err != nil {
+			return "", 0, nil, err
+		}
+		nameSize, err := strconv.ParseInt(string(header[53:59]), 8, 64)
+		if err != nil {
+			return "", 0, nil, fmt.Errorf("invalid cpio header: %w", err)
+		}
+		size, err := strconv.ParseInt(string(header[59:70]), 8, 64)
+		if err != nil {
+			return "", 0, nil, fmt.Errorf("invalid cpio header: %w", err)
+		}
+		name, err := readCPIOName(r, nameSize, 0)
+		noPadding := func(int64) int64 { return 0 }
+
+		return name, size, noPadding, err
+	case cpioNewcMagic, cpioNewcCRCMagic:
+		// ino, mode, uid, gid, nlink, mtime, filesize, devmajor, devminor, rdevmajor, rdevminor, namesize, check
+		// (8 hex digits each), the name and the data are padded to a multiple of 4 bytes
+		header := make([]byte, 104)
+		if _, err := io.ReadFull(r, header); err != nil {
+			return "", 0, nil, err
+		}
+		size, err := strconv.ParseInt(string(header[48:56]), 16, 64)
+		if err != nil {
+			return "", 0, nil, fmt.Errorf("invalid cpio header: %w", err)
+		}
+		nameSize, err := strconv.ParseInt(string(header[88:96]), 16, 64)
+		if err != nil {
+			return "", 0, nil, fmt.Errorf("invalid cpio header: %w", err)
+		}
+		name, err := readCPIOName(r, nameSize, (4-(110+nameSize)%4)%4)
+		padTo4 := func(n int64) int64 { return (4 - n%4) % 4 }
+
+		return name, size, padTo4, err
+	default:
+		return "", 0, nil, fmt.Errorf("unsupported cpio format: %q", magic)
+	}
+}
+
+func readCPIOName(r io.Reader, size, padding int64) (string, error) {
+	if size < 1 || size > pkgMetadataMaxSize {
+		return "", fmt.Errorf("invalid cpio name size: %d", size)
+	}
+	name := make([]byte, size+padding)
+	if _, err := io.ReadFull(r, name); err != nil {
+		return "", err
+	}
+
+	// The name is NUL terminated
+	return string(bytes.TrimRight(name[:size], "\x00")), nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_readPKGDetails(t *testing.T) {
+	tests := []struct {
+		name    string
+		pkg     string
+		want    packageDetails
+		wantErr string
+	}{
+		{
+			name: "Product archive with gzip compressed odc payload",
+			pkg:  "product.pkg",
+			want: packageDetails{bundleID: "com.example.app", bundleVersion: "42", bundleShortVersionString: "1.0"},
+		},
+		{
+			name: "Component package with newc payload and binary Info.plist",
+			pkg:  "component.pkg",
+			want: packageDetails{bundleID: "com.example.app", bundleVersion: "42", bundleShortVersionString: "1.0"},
+		},
+		{
+			name: "Unsupported payload falls back to PackageInfo",
+			pkg:  "pbzx_payload.pkg",
+			want: packageDetails{bundleID: "com.example.app", bundleVersion: "43", bundleShortVersionString: "1.1"},
+		},
+		{
+			name:    "No app bundle",
+			pkg:     "no_app.pkg",
+			wantErr: "no app bundle found in the package",
+		},
+		{
+			name:    "Not a xar archive",
+			pkg:     "../altool/unknown_error.json",
+			wantErr: "failed to open package: not a xar archive",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := readPKGDetails(filepath.Join("testdata", "pkg", tt.pkg))
+
+			if tt.wantErr != "" {
+				require.EqualError(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_readPackageDetails_pkg(t *testing.T) {
+	got, err := readPackageDetails(nil, filepath.Join("testdata", "pkg", "product.pkg"), packageDetails{bundleVersion: "100"})
+	require.NoError(t, err)
+	require.Equal(t, packageDetails{bundleID: "com.example.app", bundleVersion: "100", bundleShortVersionString: "1.0"}, got)
+}
+
+func Test_findCPIOFile_notFound(t *testing.T) {
+	archive, err := openXar(filepath.Join("testdata", "pkg", "component.pkg"))
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, archive.close())
+	}()
+
+	payload, err := archive.open("Payload")
+	require.NoError(t, err)
+	_, err = findCPIOFile(payload, "./Missing.app/Contents/Info.plist", pkgMetadataMaxSize)
+	require.EqualError(t, err, "Missing.app/Contents/Info.plist not found in the payload")
+}
+
+func Test_readPayloadAppDetails(t *testing.T) {
+	for _, pkg := range []struct{ name, payload string }{
+		{name: "product.pkg", payload: "Example App.pkg/Payload"},
+		{name: "component.pkg", payload: "Payload"},
+	} {
+		t.Run(pkg.name, func(t *testing.T) {
+			archive, err := openXar(filepath.Join("testdata", "pkg", pkg.name))
+			require.NoError(t, err)
+			defer func() {
+				require.NoError(t, archive.close())
+			}()
+
+			// The Info.plist of the app, not the one of its plug-in
+			got, err := readPayloadAppDetails(archive, pkg.payload, "./Example.app")
+			require.NoError(t, err)
+			require.Equal(t, packageDetails{bundleID: "com.example.app", bundleVersion: "42", bundleShortVersionString: "1.0"}, got)
+		})
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/v2/log"
+	"github.com/bitrise-io/go-xcode/certificateutil"
+	"github.com/fullsailor/pkcs7"
+)
+
+// xarMaxSignatureSize limits the size of the TOC checksum and signature read into memory
+const xarMaxSignatureSize = 1 << 20
+
+// macAppStoreInstallerIdentities are the common name prefixes of the certificates accepted for Mac App Store packages,
+// Mac Installer Distribution is the current name of 3rd Party Mac Developer Installer certificates
+var macAppStoreInstallerIdentities = []string{
+	"3rd Party Mac Developer Installer",
+	"Mac Installer Distribution",
+	"Apple Distribution",
+}
+
+// readPKGSignature verifies the signature of the package's TOC, and returns the signer's certificate. The signature
+// covers the TOC checksum, and the TOC lists the checksums of the files, so the whole package is covered.
+// The certificate chain is only checked to be consistent, the Apple root certificate is not available on every host.
+func readPKGSignature(pth string) (certificateutil.CertificateInfoModel, error) {
+	archive, err := openXar(pth)
+	if err != nil {
+		return certificateutil.CertificateInfoModel{}, fmt.Errorf("failed to open package: %w", err)
+	}
+	defer func() {
+		_ = archive.close()
+	}()
+
+	signature := archive.toc.Signature
+	if signature == nil {
+		signature = archive.toc.XSignature
+	}
+	if signature == nil {
+		return certificateutil.CertificateInfoModel{}, actionableError{
+			problem: "the package is not signed",
+			advice:  "Sign the package with productbuild --sign, or export the app with the app-store-connect method.",
+		}
+	}
+
+	digest, hashFunc, err := verifyTOCChecksum(archive)
+	if err != nil {
+		return certificateutil.CertificateInfoModel{}, err
+	}
+
+	chain, err := parseCertificateChain(signature.Certificates)
+	if err != nil {
+		return certificateutil.CertificateInfoModel{}, err
+	}
+
+	signatureContent, err := archive.readHeap(signature.xarHeapEntry, xarMaxSignatureSize)
+	if err != nil {
+		return certificateutil.CertificateInfoModel{}, fmt.Errorf("failed to read the package signature: %w", err)
+	}
+	switch signature.Style {
+	case "RSA":
+		publicKey, ok := chain[0].PublicKey.(*rsa.PublicKey)
+		if !ok {
+			return certificateutil.CertificateInfoModel{}, fmt.Errorf("unsupported signing key: %T", chain[0].PublicKey)
+		}
+		if err := rsa.VerifyPKCS1v15(publicKey, hashFunc, digest, signatureContent); err != nil {
+			return certificateutil.CertificateInfoModel{}, fmt.Errorf("invalid package signature: %w", err)
+		}
+	case "CMS":
+		p7, err := pkcs7.Parse(signatureContent)
+		if err != nil {
+			return certificateutil.CertificateInfoModel{}, fmt.Errorf("invalid package signature: %w", err)
+		}
+		// The CMS signature is detached, its content is the TOC checksum
+		p7.Content = digest
+		if err := p7.Verify(); err != nil {
+			return certificateutil.CertificateInfoModel{}, fmt.Errorf("invalid package signature: %w", err)
+		}
+	default:
+		return certificateutil.CertificateInfoModel{}, fmt.Errorf("unsupported package signature style: %s", signature.Style)
+	}
+
+	return certificateutil.NewCertificateInfo(*chain[0], nil), nil
+}
+
+// verifyTOCChecksum compares the checksum stored in the heap with the checksum of the compressed TOC
+func verifyTOCChecksum(archive *xarArchive) ([]byte, crypto.Hash, error) {
+	checksum := archive.toc.Checksum
+	if checksum == nil {
+		return nil, 0, errors.New("the package has no TOC checksum")
+	}
+
+	var hashFunc crypto.Hash
+	var newHash func() hash.Hash
+	switch strings.ToLower(checksum.Style) {
+	case "sha1":
+		hashFunc, newHash = crypto.SHA1, sha1.New
+	case "sha256":
+		hashFunc, newHash = crypto.SHA256, sha256.New
+	case "sha512":
+		hashFunc, newHash = crypto.SHA512, sha512.New
+	default:
+		return nil, 0, fmt.Errorf("unsupported TOC checksum style: %s", checksum.Style)
+	}
+
+	stored, err := archive.readHeap(*checksum, xarMaxSignatureSize)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to read the TOC checksum: %w", err)
+	}
+
+	h := newHash()
+	if _, err := io.Copy(h, io.NewSectionReader(archive.file, archive.tocOffset, archive.tocLength)); err != nil {
+		return nil, 0, fmt.Errorf("failed to read the TOC: %w", err)
+	}
+	if digest := h.Sum(nil); !bytes.Equal(digest, stored) {
+		return nil, 0, errors.New("TOC checksum mismatch, the package is corrupt or was modified after signing")
+	}
+
+	return stored, hashFunc, nil
+}
+
+// parseCertificateChain parses the base64 encoded DER certificates, and checks that each is signed by the next one
+func parseCertificateChain(encoded []string) ([]*x509.Certificate, error) {
+	if len(encoded) == 0 {
+		return nil, errors.New("the package signature has no certificate")
+	}
+
+	var chain []*x509.Certificate
+	for _, content := range encoded {
+		der, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(content), ""))
+		if err != nil {
+			return nil, fmt.Errorf("invalid signing certificate: %w", err)
+		}
+		certificate, err := certificateutil.CertificateFromDERContent(der)
+		if err != nil {
+			return nil, fmt.Errorf("invalid signing certificate: %w", err)
+		}
+		chain = append(chain, certificate)
+	}
+
+	for i := 0; i+1 < len(chain); i++ {
+		if err := chain[i].CheckSignatureFrom(chain[i+1]); err != nil {
+			return nil, fmt.Errorf("invalid certificate chain, %s is not issued by %s: %w", chain[i].Subject.CommonName, chain[i+1].Subject.CommonName, err)
+		}
+	}
+
+	return chain, nil
+}
+
+// checkInstallerIdentity checks that the certificate can sign packages for the Mac App Store, and it is valid
+func checkInstallerIdentity(info certificateutil.CertificateInfoModel) error {
+	accepted := false
+	for _, identity := range macAppStoreInstallerIdentities {
+		if strings.HasPrefix(info.CommonName, identity+":") {
+			accepted = true
+			break
+		}
+	}
+	if !accepted {
+		last := len(macAppStoreInstallerIdentities) - 1
+		advice := fmt.Sprintf("Mac App Store packages must be signed with a %s or %s certificate.", strings.Join(macAppStoreInstallerIdentities[:last], ", "), macAppStoreInstallerIdentities[last])
+		if strings.HasPrefix(info.CommonName, "Developer ID Installer:") {
+			advice += " Developer ID Installer certificates are for distribution outside the Mac App Store."
+		}
+		return actionableError{
+			problem: fmt.Sprintf("the package is signed with the wrong identity: %s", info.CommonName),
+			advice:  advice + " Export the app with the app-store-connect method, or sign the package with productbuild --sign.",
+		}
+	}
+
+	if err := info.CheckValidity(); err != nil {
+		return actionableError{
+			problem: fmt.Sprintf("the signing certificate (%s) is not valid: %s", info.CommonName, err),
+			advice:  "Renew the certificate in the Apple Developer Portal, and sign the package again.",
+		}
+	}
+
+	return nil
+}
+
+// preflightPKGSignature verifies the installer signature of the package before the upload,
+// as App Store Connect rejects wrongly signed packages only after the transfer
+func preflightPKGSignature(logger log.Logger, pth string) error {
+	logger.Println()
+	logger.Infof("Verifying installer signature of %s", filepath.Base(pth))
+
+	info, err := readPKGSignature(pth)
+	if err != nil {
+		return fmt.Errorf("Installer signature verification failed: %w", err)
+	}
+	logger.Printf("Signed by: %s", info.CommonName)
+	logger.Printf("Team: %s (%s)", info.TeamName, info.TeamID)
+	logger.Printf("Expiry: %s", info.EndDate)
+
+	if err := checkInstallerIdentity(info); err != nil {
+		return fmt.Errorf("Installer signature verification failed: %w", err)
+	}
+	logger.Donef("Installer signature verified")
+
+	return nil
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bitrise-io/go-utils/v2/log"
+	"github.com/stretchr/testify/require"
+)
+
+type testSigner struct {
+	chain []*x509.Certificate
+	key   *rsa.PrivateKey
+}
+
+func newTestCertificate(t *testing.T, template *x509.Certificate, parent *x509.Certificate, parentKey *rsa.PrivateKey) (*x509.Certificate, *rsa.PrivateKey) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	require.NoError(t, err)
+	certificate, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	return certificate, key
+}
+
+// newTestSigner returns an installer certificate issued by a test intermediate CA
+func newTestSigner(t *testing.T, commonName string, notAfter time.Time) testSigner {
+	ca, caKey := newTestCertificate(t, &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Pear Worldwide Developer Relations Certification Authority"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(10, 0, 0),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil, nil)
+	leaf, key := newTestCertificate(t, &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject: pkix.Name{
+			CommonName:         commonName,
+			Organization:       []string{"Example Ltd"},
+			OrganizationalUnit: []string{"ABCDE12345"},
+		},
+		NotBefore: time.Now().Add(-2 * time.Hour),
+		NotAfter:  notAfter,
+		KeyUsage:  x509.KeyUsageDigitalSignature,
+	}, ca, caKey)
+
+	return testSigner{chain: []*x509.Certificate{leaf, ca}, key: key}
+}
+
+// writeTestPKG writes a xar archive with a single file, signed by the signer (if not nil),
+// tamper modifies the archive after signing
+func writeTestPKG(t *testing.T, signer *testSigner, tamper func(heap []byte)) string {
+	content := []byte("<pkg-info/>")
+	const checksumSize = sha1.Size
+	signatureSize := 0
+	var signature string
+	if signer != nil {
+		signatureSize = signer.key.Size()
+		var certificates string
+		for _, certificate := range signer.chain {
+			certificates += fmt.Sprintf("<X509Certificate>%s</X509Certificate>", base64.StdEncoding.EncodeToString(certificate.Raw))
+		}
+		signature = fmt.Sprintf(`<signature style="RSA"><offset>%d</offset><size>%d</size>`+
+			`<KeyInfo xmlns="http://www.w3.org/2000/09/xmldsig#"><X509Data>%s</X509Data></KeyInfo></signature>`, checksumSize, signatureSize, certificates)
+	}
+	toc := fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?><xar><toc>`+
+		`<checksum style="sha1"><offset>0</offset><size>%d</size></checksum>%s`+
+		`<file id="1"><name>PackageInfo</name><type>file</type><data><offset>%d</offset><length>%d</length><size>%d</size>`+
+		`<encoding style="application/octet-stream"/></data></file></toc></xar>`, checksumSize, signature, checksumSize+signatureSize, len(content), len(content))
+
+	var compressedTOC bytes.Buffer
+	w := zlib.NewWriter(&compressedTOC)
+	_, err := w.Write([]byte(toc))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	checksum := sha1.Sum(compressedTOC.Bytes())
+	heap := append([]byte{}, checksum[:]...)
+	if signer != nil {
+		signatureContent, err := rsa.SignPKCS1v15(rand.Reader, signer.key, crypto.SHA1, checksum[:])
+		require.NoError(t, err)
+		heap = append(heap, signatureContent...)
+	}
+	heap = append(heap, content...)
+	if tamper != nil {
+		tamper(heap)
+	}
+
+	var archive bytes.Buffer
+	require.NoError(t, binary.Write(&archive, binary.BigEndian, xarHeader{
+		Magic:                 [4]byte{'x', 'a', 'r', '!'},
+		Size:                  xarHeaderMinSize,
+		Version:               1,
+		TOCLengthCompressed:   uint64(compressedTOC.Len()),
+		TOCLengthUncompressed: uint64(len(toc)),
+		ChecksumAlgorithm:     1,
+	}))
+	archive.Write(compressedTOC.Bytes())
+	archive.Write(heap)
+
+	pth := filepath.Join(t.TempDir(), "app.pkg")
+	require.NoError(t, os.WriteFile(pth, archive.Bytes(), 0600))
+
+	return pth
+}
+
+func Test_preflightPKGSignature(t *testing.T) {
+	valid := newTestSigner(t, "3rd Party Mac Developer Installer: Example Ltd (ABCDE12345)", time.Now().AddDate(1, 0, 0))
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		pkg     func(t *testing.T) string
+		wantErr string
+	}{
+		{
+			name: "Mac App Store installer certificate",
+			pkg:  func(t *testing.T) string { return writeTestPKG(t, &valid, nil) },
+		},
+		{
+			name: "Apple Distribution certificate",
+			pkg: func(t *testing.T) string {
+				signer := newTestSigner(t, "Apple Distribution: Example Ltd (ABCDE12345)", time.Now().AddDate(1, 0, 0))
+				return writeTestPKG(t, &signer, nil)
+			},
+		},
+		{
+			name:    "Not signed",
+			pkg:     func(t *testing.T) string { return filepath.Join("testdata", "pkg", "product.pkg") },
+			wantErr: "the package is not signed",
+		},
+		{
+			name: "Developer ID certificate",
+			pkg: func(t *testing.T) string {
+				signer := newTestSigner(t, "Developer ID Installer: Example Ltd (ABCDE12345)", time.Now().AddDate(1, 0, 0))
+				return writeTestPKG(t, &signer, nil)
+			},
+			wantErr: "Developer ID Installer certificates are for distribution outside the Mac App Store",
+		},
+		{
+			name: "Expired certificate",
+			pkg: func(t *testing.T) string {
+				signer := newTestSigner(t, "3rd Party Mac Developer Installer: Example Ltd (ABCDE12345)", time.Now().Add(-time.Hour))
+				return writeTestPKG(t, &signer, nil)
+			},
+			wantErr: "is not valid: Certificate is not valid anymore",
+		},
+		{
+			name: "Modified TOC checksum",
+			pkg: func(t *testing.T) string {
+				return writeTestPKG(t, &valid, func(heap []byte) { heap[0] ^= 0xff })
+			},
+			wantErr: "TOC checksum mismatch",
+		},
+		{
+			name: "Signed by a different key",
+			pkg: func(t *testing.T) string {
+				signer := testSigner{chain: valid.chain, key: otherKey}
+				return writeTestPKG(t, &signer, nil)
+			},
+			wantErr: "invalid package signature: crypto/rsa: verification error",
+		},
+		{
+			name: "Broken certificate chain",
+			pkg: func(t *testing.T) string {
+				other := newTestSigner(t, "3rd Party Mac Developer Installer: Other Ltd (FGHIJ67890)", time.Now().AddDate(1, 0, 0))
+				signer := testSigner{chain: []*x509.Certificate{valid.chain[0], other.chain[1]}, key: valid.key}
+				return writeTestPKG(t, &signer, nil)
+			},
+			wantErr: "invalid certificate chain",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := preflightPKGSignature(log.NewLogger(), tt.pkg(t))
+
+			if tt.wantErr != "" {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
+
+func Test_readPKGSignature(t *testing.T) {
+	signer := newTestSigner(t, "3rd Party Mac Developer Installer: Example Ltd (ABCDE12345)", time.Now().AddDate(1, 0, 0))
+
+	info, err := readPKGSignature(writeTestPKG(t, &signer, nil))
+	require.NoError(t, err)
+	require.Equal(t, "3rd Party Mac Developer Installer: Example Ltd (ABCDE12345)", info.CommonName)
+	require.Equal(t, "ABCDE12345", info.TeamID)
+	require.Equal(t, "Example Ltd", info.TeamName)
+	require.True(t, info.EndDate.Equal(signer.chain[0].NotAfter))
+}
+
+func Test_preflightPKGSignature_wrongIdentity(t *testing.T) {
+	signer := newTestSigner(t, "Apple Development: Jane Doe (ABCDE12345)", time.Now().AddDate(1, 0, 0))
+
+	err := preflightPKGSignature(log.NewLogger(), writeTestPKG(t, &signer, nil))
+	require.Error(t, err)
+	require.True(t, strings.HasPrefix(err.Error(), "Installer signature verification failed: the package is signed with the wrong identity: Apple Development: Jane Doe (ABCDE12345)\n"))
+	require.Contains(t, err.Error(), "3rd Party Mac Developer Installer, Mac Installer Distribution or Apple Distribution certificate")
+}
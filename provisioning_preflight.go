@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/bitrise-io/go-utils/v2/log"
+	"github.com/bitrise-io/go-xcode/exportoptions"
+	"github.com/bitrise-io/go-xcode/v2/metaparser"
+)
+
+const appStoreExportAdvice = "Export the IPA with the app-store-connect distribution method, signed with an App Store distribution provisioning profile."
+
+// checkProvisioningProfile checks that the profile is a valid App Store distribution profile,
+// App Store Connect rejects builds signed with other profiles only after the upload
+func checkProvisioningProfile(info metaparser.ProvisionInfo, now time.Time) error {
+	switch {
+	case info.IPAExportMethod != exportoptions.MethodAppStore && info.IPAExportMethod != exportoptions.MethodAppStoreConnect:
+		return actionableError{
+			problem: fmt.Sprintf("the IPA is signed with the %s provisioning profile (export method: %s), not an App Store distribution profile", info.ProfileName, info.IPAExportMethod),
+			advice:  appStoreExportAdvice,
+		}
+	case info.ProvisionsAllDevices:
+		return actionableError{
+			problem: fmt.Sprintf("the provisioning profile (%s) provisions all devices, App Store distribution profiles have no device list", info.ProfileName),
+			advice:  appStoreExportAdvice,
+		}
+	case len(info.DeviceUDIDList) > 0:
+		return actionableError{
+			problem: fmt.Sprintf("the provisioning profile (%s) lists %d devices, App Store distribution profiles have no device list", info.ProfileName, len(info.DeviceUDIDList)),
+			advice:  appStoreExportAdvice,
+		}
+	case !now.Before(info.ExpireDate):
+		return actionableError{
+			problem: fmt.Sprintf("the provisioning profile (%s) expired at %s", info.ProfileName, info.ExpireDate),
+			advice:  "Regenerate the profile in the Apple Developer Portal, and export the IPA again.",
+		}
+	}
+
+	return nil
+}
+
+// preflightProvisioningProfile checks the provisioning profile embedded in the IPA before the upload.
+// The export method is derived from the profile by metaparser, which can misclassify some (for example enterprise
+// or older) profiles, so the check only warns by default.
+func preflightProvisioningProfile(logger log.Logger, parser *metaparser.Parser, pth string) error {
+	// metaparser reads IPA files only, PKG files embed their profile in the app bundle inside the package
+	if filepath.Ext(pth) != ".ipa" {
+		return nil
+	}
+
+	logger.Println()
+	logger.Infof("Checking provisioning profile of %s", filepath.Base(pth))
+
+	metadata, err := parser.ParseIPAData(pth)
+	if err != nil {
+		return fmt.Errorf("Provisioning profile check failed: could not read the embedded provisioning profile: %w", err)
+	}
+	info := metadata.ProvisioningInfo
+	logger.Printf("Profile: %s", info.ProfileName)
+	logger.Printf("Team: %s", info.TeamName)
+	logger.Printf("Export method: %s", info.IPAExportMethod)
+	logger.Printf("Expiry: %s", info.ExpireDate)
+
+	if err := checkProvisioningProfile(info, time.Now()); err != nil {
+		return fmt.Errorf("Provisioning profile check failed: %w", err)
+	}
+	logger.Donef("Provisioning profile is an App Store distribution profile")
+
+	return nil
+}
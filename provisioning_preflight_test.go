@@ -0,0 +1,231 @@
+package main
+
+import (
+	"archive/zip"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	fileutilv2 "github.com/bitrise-io/go-utils/v2/fileutil"
+	"github.com/bitrise-io/go-utils/v2/log"
+	"github.com/bitrise-io/go-xcode/exportoptions"
+	"github.com/bitrise-io/go-xcode/v2/metaparser"
+	"github.com/fullsailor/pkcs7"
+	"github.com/stretchr/testify/require"
+)
+
+// testPlist encodes the value as an XML property list, it supports the types used by the tests only
+func testPlist(value interface{}) string {
+	var encode func(value interface{}) string
+	encode = func(value interface{}) string {
+		switch v := value.(type) {
+		case string:
+			return "<string>" + v + "</string>"
+		case bool:
+			return fmt.Sprintf("<%t/>", v)
+		case time.Time:
+			return "<date>" + v.UTC().Format(time.RFC3339) + "</date>"
+		case []string:
+			var items string
+			for _, item := range v {
+				items += encode(item)
+			}
+			return "<array>" + items + "</array>"
+		case map[string]interface{}:
+			keys := make([]string, 0, len(v))
+			for key := range v {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			var entries string
+			for _, key := range keys {
+				entries += "<key>" + key + "</key>" + encode(v[key])
+			}
+			return "<dict>" + entries + "</dict>"
+		default:
+			panic(fmt.Sprintf("unsupported plist value: %T", value))
+		}
+	}
+
+	return `<?xml version="1.0" encoding="UTF-8"?>` +
+		`<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">` +
+		`<plist version="1.0">` + encode(value) + `</plist>`
+}
+
+// newTestProvisioningProfile returns an App Store distribution profile, overrides replace its keys
+func newTestProvisioningProfile(t *testing.T, overrides map[string]interface{}) []byte {
+	profile := map[string]interface{}{
+		"Name":           "Example App Store",
+		"UUID":           "5b0a4fe8-0b6f-4b5b-9d7c-1d4a5a5d9b4e",
+		"TeamName":       "Example Ltd",
+		"TeamIdentifier": []string{"ABCDE12345"},
+		"Platform":       []string{"iOS"},
+		"CreationDate":   time.Now().Add(-time.Hour),
+		"ExpirationDate": time.Now().AddDate(1, 0, 0),
+		"Entitlements": map[string]interface{}{
+			"application-identifier":              "ABCDE12345.com.example.app",
+			"com.apple.developer.team-identifier": "ABCDE12345",
+			"get-task-allow":                      false,
+		},
+	}
+	for key, value := range overrides {
+		if value == nil {
+			delete(profile, key)
+		} else {
+			profile[key] = value
+		}
+	}
+
+	signer := newTestSigner(t, "Apple iPhone OS Provisioning Profile Signing", time.Now().AddDate(1, 0, 0))
+	signedData, err := pkcs7.NewSignedData([]byte(testPlist(profile)))
+	require.NoError(t, err)
+	require.NoError(t, signedData.AddSigner(signer.chain[0], signer.key, pkcs7.SignerInfoConfig{}))
+	content, err := signedData.Finish()
+	require.NoError(t, err)
+
+	return content
+}
+
+// writeTestIPA writes an IPA with the files of the Example.app bundle, and an Info.plist if not provided
+func writeTestIPA(t *testing.T, files map[string][]byte) string {
+	pth := filepath.Join(t.TempDir(), "Example.ipa")
+	f, err := os.Create(pth)
+	require.NoError(t, err)
+
+	if _, ok := files["Info.plist"]; !ok {
+		files["Info.plist"] = []byte(testPlist(map[string]interface{}{
+			"CFBundleIdentifier":         "com.example.app",
+			"CFBundleVersion":            "42",
+			"CFBundleShortVersionString": "1.0",
+			"DTPlatformName":             "iphoneos",
+		}))
+	}
+
+	w := zip.NewWriter(f)
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fw, err := w.Create(filepath.Join("Payload", "Example.app", name))
+		require.NoError(t, err)
+		_, err = fw.Write(files[name])
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.Close())
+	require.NoError(t, f.Close())
+
+	return pth
+}
+
+func Test_checkProvisioningProfile(t *testing.T) {
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	appStore := metaparser.ProvisionInfo{
+		ProfileName:     "Example App Store",
+		TeamName:        "Example Ltd",
+		ExpireDate:      now.AddDate(0, 6, 0),
+		IPAExportMethod: exportoptions.MethodAppStore,
+	}
+
+	tests := []struct {
+		name    string
+		modify  func(info *metaparser.ProvisionInfo)
+		wantErr string
+	}{
+		{name: "App Store profile", modify: func(info *metaparser.ProvisionInfo) {}},
+		{
+			name:   "App Store Connect export method",
+			modify: func(info *metaparser.ProvisionInfo) { info.IPAExportMethod = exportoptions.MethodAppStoreConnect },
+		},
+		{
+			name: "Ad hoc profile",
+			modify: func(info *metaparser.ProvisionInfo) {
+				info.IPAExportMethod = exportoptions.MethodAdHoc
+				info.DeviceUDIDList = []string{"00008030-001A2B3C4D5E6F70"}
+			},
+			wantErr: "the IPA is signed with the Example App Store provisioning profile (export method: ad-hoc), not an App Store distribution profile",
+		},
+		{
+			name:    "Development profile",
+			modify:  func(info *metaparser.ProvisionInfo) { info.IPAExportMethod = exportoptions.MethodDevelopment },
+			wantErr: "the IPA is signed with the Example App Store provisioning profile (export method: development)",
+		},
+		{
+			name:    "Provisions all devices",
+			modify:  func(info *metaparser.ProvisionInfo) { info.ProvisionsAllDevices = true },
+			wantErr: "the provisioning profile (Example App Store) provisions all devices",
+		},
+		{
+			name:    "Device list",
+			modify:  func(info *metaparser.ProvisionInfo) { info.DeviceUDIDList = []string{"a", "b"} },
+			wantErr: "the provisioning profile (Example App Store) lists 2 devices",
+		},
+		{
+			name:    "Expired",
+			modify:  func(info *metaparser.ProvisionInfo) { info.ExpireDate = now.Add(-time.Minute) },
+			wantErr: "the provisioning profile (Example App Store) expired at 2026-10-16 11:59:00 +0000 UTC",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info := appStore
+			tt.modify(&info)
+
+			err := checkProvisioningProfile(info, now)
+
+			if tt.wantErr != "" {
+				require.Error(t, err)
+				require.True(t, strings.HasPrefix(err.Error(), tt.wantErr), err.Error())
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
+
+func Test_preflightProvisioningProfile(t *testing.T) {
+	parser := metaparser.New(log.NewLogger(), fileutilv2.NewFileManager())
+
+	tests := []struct {
+		name    string
+		files   map[string][]byte
+		wantErr string
+	}{
+		{
+			name:  "App Store profile",
+			files: map[string][]byte{"embedded.mobileprovision": newTestProvisioningProfile(t, nil)},
+		},
+		{
+			name: "Ad hoc profile",
+			files: map[string][]byte{"embedded.mobileprovision": newTestProvisioningProfile(t, map[string]interface{}{
+				"Name":               "Example Ad Hoc",
+				"ProvisionedDevices": []string{"00008030-001A2B3C4D5E6F70"},
+			})},
+			wantErr: "Provisioning profile check failed: the IPA is signed with the Example Ad Hoc provisioning profile (export method: ad-hoc)",
+		},
+		{
+			name:    "No embedded profile",
+			files:   map[string][]byte{},
+			wantErr: "Provisioning profile check failed: could not read the embedded provisioning profile",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := preflightProvisioningProfile(log.NewLogger(), parser, writeTestIPA(t, tt.files))
+
+			if tt.wantErr != "" {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+
+	require.NoError(t, preflightProvisioningProfile(log.NewLogger(), parser, filepath.Join(t.TempDir(), "macos.pkg")), "PKG files are not parsed")
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+)
+
+const redactedPlaceholder = "[REDACTED]"
+
+// Tokens are redacted by pattern too, as they are generated during the run (JWTs)
+// or printed by altool (Xcode 16, but not Xcode 26 altool prints the bearer token to stderr)
+var (
+	bearerTokenPattern = regexp.MustCompile(`(?i)(\bBearer\s*)[^\s"',;]+`)
+	jwtPattern         = regexp.MustCompile(`eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`)
+)
+
+// redactor masks the secrets known by the step, it is seeded with the secret Inputs and the selected credentials
+type redactor struct {
+	mu      sync.RWMutex
+	secrets []string
+}
+
+func newRedactor(secrets ...string) *redactor {
+	r := &redactor{}
+	r.add(secrets...)
+
+	return r
+}
+
+// add registers secrets to mask, multi-line secrets (like the API private key) are masked line by line too
+func (r *redactor) add(secrets ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, secret := range secrets {
+		candidates := []string{secret}
+		if strings.Contains(secret, "\n") {
+			candidates = append(candidates, strings.Split(secret, "\n")...)
+		}
+		for _, candidate := range candidates {
+			candidate = strings.TrimSpace(candidate)
+			// PEM boundaries are not secret
+			if candidate == "" || strings.HasPrefix(candidate, "-----") || slices.Contains(r.secrets, candidate) {
+				continue
+			}
+			r.secrets = append(r.secrets, candidate)
+		}
+	}
+	// Longer secrets first, so a secret containing another one is masked completely
+	slices.SortFunc(r.secrets, func(a, b string) int { return len(b) - len(a) })
+}
+
+func (r *redactor) redact(s string) string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, secret := range r.secrets {
+		s = strings.ReplaceAll(s, secret, redactedPlaceholder)
+	}
+	s = jwtPattern.ReplaceAllString(s, redactedPlaceholder)
+	s = bearerTokenPattern.ReplaceAllString(s, "${1}"+redactedPlaceholder)
+
+	return s
+}
+
+// redactingWriter masks the secrets in the output written to it. Output is passed on by complete lines,
+// so a secret split across multiple writes is masked too.
+type redactingWriter struct {
+	mu       sync.Mutex
+	w        io.Writer
+	redactor *redactor
+	buf      []byte
+}
+
+func newRedactingWriter(w io.Writer, redactor *redactor) *redactingWriter {
+	return &redactingWriter{w: w, redactor: redactor}
+}
+
+func (w *redactingWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.buf = append(w.buf, p...)
+	i := bytes.LastIndexByte(w.buf, '\n')
+	if i < 0 {
+		return len(p), nil
+	}
+
+	lines := string(w.buf[:i+1])
+	w.buf = append([]byte{}, w.buf[i+1:]...)
+	if _, err := io.WriteString(w.w, w.redactor.redact(lines)); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// flush writes the last line, if the output does not end with a newline
+func (w *redactingWriter) flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if len(w.buf) == 0 {
+		return nil
+	}
+	_, err := io.WriteString(w.w, w.redactor.redact(string(w.buf)))
+	w.buf = nil
+
+	return err
+}
@@ -0,0 +1,341 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/avast/retry-go/v4"
+)
+
+const (
+	retryBackoffFixed       = "fixed"
+	retryBackoffExponential = "exponential"
+
+	defaultRetryAttempts = 10
+	defaultRetryDelay    = 300 * time.Millisecond
+
+	// minRateLimitedRetryDelay is the delay of rate limited requests without a Retry-After value, if the max delay is shorter
+	minRateLimitedRetryDelay = 10 * time.Second
+)
+
+// retryPolicy configures how failed uploads are retried
+type retryPolicy struct {
+	// attempts is the maximum number of attempts, 0 means no limit
+	attempts uint
+	backoff  string
+	delay    time.Duration
+	// maxDelay caps the backoff delay and is the delay of rate limited requests without a Retry-After value,
+	// the latter is at least minRateLimitedRetryDelay
+	maxDelay time.Duration
+	jitter   bool
+	// deadline is the total time allowed for the attempts and delays, 0 means no limit
+	deadline time.Duration
+
+	// extraErrorCodes are user provided retryable altool error codes or App Store Connect error codes
+	extraErrorCodes []string
+	// extraPatterns are user provided retryable patterns, matched against the error output and the error
+	extraPatterns []*regexp.Regexp
+}
+
+// newRetryPolicy returns a policy retrying with a fixed delay, an invalid retry times value means the default number of attempts
+func newRetryPolicy(retryTimes string) retryPolicy {
+	attempts, err := strconv.ParseUint(retryTimes, 10, 32)
+	if err != nil {
+		attempts = defaultRetryAttempts
+	}
+
+	return retryPolicy{
+		attempts: uint(attempts),
+		backoff:  retryBackoffFixed,
+		delay:    defaultRetryDelay,
+	}
+}
+
+// classify applies the built-in classification, then the user provided retryable error codes and patterns,
+// a cancelled attempt or one rejected by every credential is never retried
+func (p retryPolicy) classify(err error, result altoolResult, errorOut string) retryDecision {
+	decision := classifyUploadError(err, result, errorOut)
+	if decision.retryable || errors.Is(err, context.Canceled) || errors.As(err, new(credentialsRejectedError)) {
+		return decision
+	}
+
+	productErrors := result.ProductErrors
+	var apiErr apiError
+	if len(productErrors) == 0 && errors.As(err, &apiErr) {
+		productErrors = apiErr.productErrors()
+	}
+	for _, productErr := range flattenProductErrors(productErrors) {
+		codes := []string{strconv.Itoa(productErr.Code), productErr.UserInfo.IrisCode, productErr.UserInfo.Code}
+		for _, code := range p.extraErrorCodes {
+			if code != "" && slices.Contains(codes, code) {
+				return retryDecision{retryable: true, reason: fmt.Sprintf("error code %s is configured as retryable", code)}
+			}
+		}
+	}
+	for _, pattern := range p.extraPatterns {
+		if pattern.MatchString(errorOut) || (err != nil && pattern.MatchString(err.Error())) {
+			return retryDecision{retryable: true, reason: fmt.Sprintf("error matches the configured pattern %s", pattern)}
+		}
+	}
+
+	return decision
+}
+
+// options returns the retry-go options of the policy, start is the time of the first attempt
+func (p retryPolicy) options(start time.Time) []retry.Option {
+	return []retry.Option{
+		retry.Attempts(p.attempts),
+		retry.Delay(p.delay),
+		retry.DelayType(func(n uint, err error, config *retry.Config) time.Duration {
+			// FixedDelay returns the configured base delay, so the retry.Delay option still applies
+			return p.nextDelay(n, err, retry.FixedDelay(n, err, config), time.Since(start))
+		}),
+	}
+}
+
+// nextDelay returns the delay before the nth retry, it never exceeds the remaining time until the deadline
+func (p retryPolicy) nextDelay(n uint, err error, baseDelay, elapsed time.Duration) time.Duration {
+	var delay time.Duration
+	var rateLimitErr rateLimitedError
+	if errors.As(err, &rateLimitErr) {
+		// The server tells when to retry, so no backoff and jitter is applied
+		delay = rateLimitErr.retryAfter
+		if delay <= 0 {
+			delay = max(p.maxDelay, minRateLimitedRetryDelay)
+		}
+	} else {
+		delay = baseDelay
+		if p.backoff == retryBackoffExponential && n > 1 {
+			delay = baseDelay << min(n-1, 30)
+		}
+		if p.maxDelay > 0 && delay > p.maxDelay {
+			delay = p.maxDelay
+		}
+		if p.jitter && delay > 1 {
+			// Equal jitter: half of the delay is kept, so retries still back off
+			delay = delay/2 + time.Duration(rand.Int63n(int64(delay/2)))
+		}
+	}
+
+	if p.deadline > 0 && elapsed+delay > p.deadline {
+		delay = max(p.deadline-elapsed, 0)
+	}
+
+	return delay
+}
+
+// deadlineExceeded tells whether there is no time left for another attempt
+func (p retryPolicy) deadlineExceeded(elapsed time.Duration) bool {
+	return p.deadline > 0 && elapsed >= p.deadline
+}
+
+// rateLimitedError is a retryable error with the delay requested by the server
+type rateLimitedError struct {
+	err        error
+	retryAfter time.Duration
+}
+
+func (e rateLimitedError) Error() string {
+	return e.err.Error()
+}
+
+func (e rateLimitedError) Unwrap() error {
+	return e.err
+}
+
+// parseRetryAfter parses a Retry-After value, given in seconds or as an HTTP date
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return max(date.Sub(now), 0), true
+	}
+
+	return 0, false
+}
+
+// retryableErrorCodes are the altool and Foundation error codes of transient failures
+var retryableErrorCodes = map[int]string{
+	-19209: "unable to authenticate",
+	-19201: "unable to determine the application",
+	-19237: "server returned unexpected content",
+	-18000: "transporter service error",
+	-26000: "server returned an invalid response",
+	-1001:  "request timed out",
+	-1005:  "network connection lost",
+	-1009:  "offline",
+	1194:   "unable to determine app platform",
+}
+
+// permanentIrisCodePrefixes are the App Store Connect error code categories which do not resolve on retry,
+// for example a duplicate bundle version or a reached upload limit.
+var permanentIrisCodePrefixes = []string{
+	"ENTITY_ERROR",
+	"STATE_ERROR",
+	"PARAMETER_ERROR",
+	"FORBIDDEN_ERROR",
+}
+
+// retryableOutputPatterns are matched against the error output, when it can not be classified by error codes
+var retryableOutputPatterns = []*regexp.Regexp{
+	// https://bitrise.atlassian.net/browse/STEP-1190
+	regexp.MustCompile(`(?s).*Unable to determine the application using bundleId.*-19201.*`),
+	regexp.MustCompile(`(?s).*Unable to determine app platform for 'Undefined' software type.*1194.*`),
+	regexp.MustCompile(`(?s).*TransporterService.*error occurred trying to read the bundle.*-18000.*`),
+	regexp.MustCompile(`(?s).*Unable to authenticate.*-19209.*`),
+	regexp.MustCompile(`(?s).*server returned an invalid response.*try your request again.*`),
+	regexp.MustCompile(`(?s).*The request timed out.*`),
+}
+
+// embeddedErrorCodePattern matches the error codes of the NSError descriptions embedded in user info values
+var embeddedErrorCodePattern = regexp.MustCompile(`Code=(-?\d+)`)
+
+// embeddedStatusPattern matches the HTTP status of the NSError descriptions embedded in user info values
+var embeddedStatusPattern = regexp.MustCompile(`status=(\d{3})`)
+
+// retryDecision tells whether a failed upload should be retried, and why
+type retryDecision struct {
+	retryable bool
+	reason    string
+	// rateLimited is set if the server asked to slow down, retryAfter is the requested delay if known
+	rateLimited bool
+	retryAfter  time.Duration
+}
+
+// classifyUploadError decides whether the failed upload is retryable. A timed out attempt is retryable, a cancelled one
+// or one rejected by every fallback credential is not. The product errors (and their underlying errors) are classified by their error code, App Store Connect error
+// code and HTTP status, a permanent error takes precedence over a retryable one. The error output is only matched
+// against text patterns if the product errors are inconclusive.
+func classifyUploadError(err error, result altoolResult, errorOut string) retryDecision {
+	var rejectedErr credentialsRejectedError
+	switch {
+	case errors.Is(err, context.Canceled):
+		return retryDecision{reason: "cancelled"}
+	case errors.As(err, &rejectedErr):
+		return retryDecision{reason: "every credential was rejected"}
+	case errors.Is(err, context.DeadlineExceeded):
+		// A stuck Transporter session likely succeeds on a new attempt
+		return retryDecision{retryable: true, reason: "attempt timed out"}
+	}
+
+	productErrors := result.ProductErrors
+	var apiErr apiError
+	if len(productErrors) == 0 && errors.As(err, &apiErr) {
+		productErrors = apiErr.productErrors()
+	}
+
+	var retryable *retryDecision
+	for _, productErr := range flattenProductErrors(productErrors) {
+		decision, ok := classifyProductError(productErr)
+		if !ok {
+			continue
+		}
+		if !decision.retryable {
+			return decision
+		}
+		if retryable == nil {
+			retryable = &decision
+		}
+	}
+	if retryable != nil {
+		return *retryable
+	}
+
+	for _, pattern := range retryableOutputPatterns {
+		if pattern.MatchString(errorOut) {
+			return retryDecision{retryable: true, reason: fmt.Sprintf("error output matches %s", pattern)}
+		}
+	}
+
+	return retryDecision{reason: "unrecognized error"}
+}
+
+// classifyProductError returns false if the error is neither known to be retryable nor permanent
+func classifyProductError(productErr productError) (retryDecision, bool) {
+	info := productErr.UserInfo
+	if info == (userInfo{}) {
+		info = productErr.LegacyUserInfo
+	}
+
+	for _, irisCode := range []string{info.IrisCode, info.Code} {
+		for _, prefix := range permanentIrisCodePrefixes {
+			if strings.HasPrefix(irisCode, prefix) {
+				return retryDecision{reason: fmt.Sprintf("App Store Connect error code %s", irisCode)}, true
+			}
+		}
+	}
+
+	if retryAfter, ok := parseRetryAfter(info.RetryAfter, time.Now()); ok {
+		return retryDecision{retryable: true, reason: fmt.Sprintf("rate limited, retry after %s", retryAfter), rateLimited: true, retryAfter: retryAfter}, true
+	}
+
+	statuses := []string{info.Status}
+	for _, match := range embeddedStatusPattern.FindAllStringSubmatch(info.NSUnderlyingError, -1) {
+		statuses = append(statuses, match[1])
+	}
+	for _, status := range statuses {
+		if decision, ok := classifyHTTPStatus(status); ok {
+			return decision, true
+		}
+	}
+
+	codes := []int{productErr.Code}
+	for _, value := range []string{info.NSUnderlyingError, info.NSLocalizedFailureReason} {
+		for _, match := range embeddedErrorCodePattern.FindAllStringSubmatch(value, -1) {
+			if code, err := strconv.Atoi(match[1]); err == nil {
+				codes = append(codes, code)
+			}
+		}
+	}
+	for _, code := range codes {
+		if description, ok := retryableErrorCodes[code]; ok {
+			return retryDecision{retryable: true, reason: fmt.Sprintf("error code %d (%s)", code, description)}, true
+		}
+	}
+
+	return retryDecision{}, false
+}
+
+func classifyHTTPStatus(status string) (retryDecision, bool) {
+	code, err := strconv.Atoi(status)
+	if err != nil {
+		return retryDecision{}, false
+	}
+
+	switch {
+	case code == http.StatusTooManyRequests:
+		return retryDecision{retryable: true, reason: "HTTP status 429, rate limited", rateLimited: true}, true
+	case code == 408 || code >= 500:
+		return retryDecision{retryable: true, reason: fmt.Sprintf("HTTP status %d", code)}, true
+	case code == 401:
+		// Authentication errors are reported with a retryable error code too, see -19209
+		return retryDecision{}, false
+	case code >= 400:
+		return retryDecision{reason: fmt.Sprintf("HTTP status %d", code)}, true
+	default:
+		return retryDecision{}, false
+	}
+}
+
+func flattenProductErrors(productErrors []productError) []productError {
+	var flattened []productError
+	for _, productErr := range productErrors {
+		flattened = append(flattened, productErr)
+		flattened = append(flattened, flattenProductErrors(productErr.UnderlyingErrors)...)
+	}
+
+	return flattened
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/bitrise-io/go-utils/v2/log"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_classifyUploadError(t *testing.T) {
+	tests := []struct {
+		name            string
+		fixture         string
+		err             error
+		errorOut        string
+		wantRetryable   bool
+		wantReason      string
+		wantRateLimited bool
+		wantRetryAfter  time.Duration
+	}{
+		{
+			name:          "Unable to authenticate",
+			fixture:       "unable_to_authenticate.json",
+			wantRetryable: true,
+			wantReason:    "error code -19209 (unable to authenticate)",
+		},
+		{
+			name:       "Rejected by every fallback credential",
+			fixture:    "unable_to_authenticate.json",
+			err:        credentialsRejectedError{err: errors.New("Unable to authenticate. (-19209)")},
+			wantReason: "every credential was rejected",
+		},
+		{
+			name:       "Upload limit reached",
+			fixture:    "upload_limit_reached.json",
+			wantReason: "App Store Connect error code STATE_ERROR.VALIDATION_ERROR",
+		},
+		{
+			name:       "Duplicate bundle version",
+			fixture:    "duplicate_bundle_version.json",
+			wantReason: "App Store Connect error code ENTITY_ERROR.ATTRIBUTE.INVALID.DUPLICATE",
+		},
+		{
+			name:          "Server returned unexpected content",
+			fixture:       "server_unexpected_content.json",
+			wantRetryable: true,
+			wantReason:    "error code -19237 (server returned unexpected content)",
+		},
+		{
+			name:          "Request timed out",
+			fixture:       "request_timed_out.json",
+			wantRetryable: true,
+			wantReason:    "error code -1001 (request timed out)",
+		},
+		{
+			name:          "Xcode 16 authentication failure in failure reason",
+			fixture:       "xcode16_failure_to_authenticate.json",
+			wantRetryable: true,
+			wantReason:    "error code -26000 (server returned an invalid response)",
+		},
+		{
+			name:       "Invalid bundle",
+			fixture:    "invalid_bundle.json",
+			wantReason: "App Store Connect error code STATE_ERROR.VALIDATION_ERROR.90062",
+		},
+		{
+			name:       "Unknown error code",
+			fixture:    "unknown_error.json",
+			wantReason: "unrecognized error",
+		},
+		{
+			name:          "Unknown error code, retryable error output",
+			fixture:       "unknown_error.json",
+			errorOut:      unableToDetermine,
+			wantRetryable: true,
+			wantReason:    "error output matches (?s).*Unable to determine the application using bundleId.*-19201.*",
+		},
+		{
+			name:          "No JSON output, retryable error output",
+			errorOut:      requestTimedOut,
+			wantRetryable: true,
+			wantReason:    "error output matches (?s).*The request timed out.*",
+		},
+		{
+			name:       "No JSON output, unknown error output",
+			errorOut:   "unknown-error",
+			wantReason: "unrecognized error",
+		},
+		{
+			name:          "App Store Connect API server error",
+			err:           apiError{statusCode: 503},
+			wantRetryable: true,
+			wantReason:    "HTTP status 503",
+		},
+		{
+			name:            "App Store Connect API rate limit",
+			err:             apiError{statusCode: 429, errors: []apiErrorItem{{Status: "429", Code: "RATE_LIMIT_EXCEEDED", Title: "The request rate limit has been reached."}}},
+			wantRetryable:   true,
+			wantReason:      "HTTP status 429, rate limited",
+			wantRateLimited: true,
+		},
+		{
+			name:            "App Store Connect API rate limit with Retry-After",
+			err:             apiError{statusCode: 429, retryAfter: "30", errors: []apiErrorItem{{Status: "429", Code: "RATE_LIMIT_EXCEEDED", Title: "The request rate limit has been reached."}}},
+			wantRetryable:   true,
+			wantReason:      "rate limited, retry after 30s",
+			wantRateLimited: true,
+			wantRetryAfter:  30 * time.Second,
+		},
+		{
+			name:          "Attempt timed out",
+			err:           fmt.Errorf("altool attempt timed out after 30m0s: %w", context.DeadlineExceeded),
+			errorOut:      "unknown-error",
+			wantRetryable: true,
+			wantReason:    "attempt timed out",
+		},
+		{
+			name:       "Attempt cancelled",
+			fixture:    "request_timed_out.json",
+			err:        fmt.Errorf("altool was cancelled: %w", context.Canceled),
+			wantReason: "cancelled",
+		},
+		{
+			name:       "App Store Connect API conflict",
+			err:        apiError{statusCode: 409, errors: []apiErrorItem{{Status: "409", Code: "ENTITY_ERROR.ATTRIBUTE.INVALID.DUPLICATE", Title: "The provided entity includes an attribute with a value that has already been used"}}},
+			wantReason: "App Store Connect error code ENTITY_ERROR.ATTRIBUTE.INVALID.DUPLICATE",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var result altoolResult
+			err := tt.err
+			if tt.fixture != "" {
+				stdOut, readErr := os.ReadFile(filepath.Join("testdata", "altool", tt.fixture))
+				require.NoError(t, readErr)
+				var parseErr error
+				result, parseErr = parseAltoolOutput(log.NewLogger(), string(stdOut), tt.errorOut, true)
+				require.Error(t, parseErr)
+				if err == nil {
+					err = parseErr
+				}
+			}
+			if err == nil {
+				err = errors.New("test-error")
+			}
+
+			got := classifyUploadError(err, result, tt.errorOut)
+
+			require.Equal(t, retryDecision{retryable: tt.wantRetryable, reason: tt.wantReason, rateLimited: tt.wantRateLimited, retryAfter: tt.wantRetryAfter}, got)
+		})
+	}
+}
+
+func Test_retryPolicy_classify(t *testing.T) {
+	policy := newRetryPolicy("10")
+	policy.extraErrorCodes = []string{"-19208", "STATE_ERROR.VALIDATION_ERROR"}
+	policy.extraPatterns = []*regexp.Regexp{regexp.MustCompile(`Service Unavailable`)}
+
+	unknown := altoolResult{ProductErrors: []productError{{Code: -19208}}}
+	require.True(t, policy.classify(errors.New("test-error"), unknown, "").retryable)
+
+	uploadLimit := altoolResult{ProductErrors: []productError{{Code: 409, UserInfo: userInfo{IrisCode: "STATE_ERROR.VALIDATION_ERROR"}}}}
+	require.Equal(t, "error code STATE_ERROR.VALIDATION_ERROR is configured as retryable", policy.classify(errors.New("test-error"), uploadLimit, "").reason)
+
+	require.True(t, policy.classify(errors.New("503 Service Unavailable"), altoolResult{}, "").retryable)
+	require.False(t, policy.classify(errors.New("test-error"), altoolResult{}, "unknown-error").retryable)
+	require.False(t, policy.classify(fmt.Errorf("503 Service Unavailable: %w", context.Canceled), altoolResult{}, "").retryable, "cancelled attempts are not retried")
+	require.False(t, policy.classify(credentialsRejectedError{err: errors.New("503 Service Unavailable")}, altoolResult{}, "").retryable, "rejected credentials are not retried")
+}
+
+func Test_retryPolicy_nextDelay(t *testing.T) {
+	policy := retryPolicy{backoff: retryBackoffExponential, maxDelay: 10 * time.Second}
+	base := time.Second
+	testErr := errors.New("test-error")
+
+	require.Equal(t, time.Second, policy.nextDelay(1, testErr, base, 0))
+	require.Equal(t, 2*time.Second, policy.nextDelay(2, testErr, base, 0))
+	require.Equal(t, 8*time.Second, policy.nextDelay(4, testErr, base, 0))
+	require.Equal(t, 10*time.Second, policy.nextDelay(5, testErr, base, 0), "capped at the max delay")
+	require.Equal(t, 10*time.Second, policy.nextDelay(100, testErr, base, 0), "no overflow")
+
+	fixed := retryPolicy{backoff: retryBackoffFixed}
+	require.Equal(t, time.Second, fixed.nextDelay(5, testErr, base, 0))
+
+	jitter := retryPolicy{backoff: retryBackoffExponential, maxDelay: 10 * time.Second, jitter: true}
+	for i := 0; i < 100; i++ {
+		delay := jitter.nextDelay(3, testErr, base, 0)
+		require.GreaterOrEqual(t, delay, 2*time.Second)
+		require.Less(t, delay, 4*time.Second)
+	}
+
+	rateLimited := rateLimitedError{err: testErr, retryAfter: 30 * time.Second}
+	require.Equal(t, 30*time.Second, policy.nextDelay(1, rateLimited, base, 0), "Retry-After is not capped by the max delay")
+	require.Equal(t, 10*time.Second, policy.nextDelay(1, rateLimitedError{err: testErr}, base, 0), "max delay without Retry-After")
+	require.Equal(t, 20*time.Second, retryPolicy{maxDelay: 20 * time.Second}.nextDelay(1, rateLimitedError{err: testErr}, base, 0))
+	require.Equal(t, minRateLimitedRetryDelay, retryPolicy{}.nextDelay(1, rateLimitedError{err: testErr}, base, 0), "minimum delay without max delay")
+
+	deadline := retryPolicy{backoff: retryBackoffFixed, deadline: time.Minute}
+	require.Equal(t, 5*time.Second, deadline.nextDelay(1, rateLimited, base, 55*time.Second), "capped at the deadline")
+	require.True(t, deadline.deadlineExceeded(time.Minute))
+	require.False(t, policy.deadlineExceeded(time.Hour), "no deadline")
+}
+
+func Test_parseRetryAfter(t *testing.T) {
+	now := time.Date(2025, 10, 1, 8, 0, 0, 0, time.UTC)
+
+	got, ok := parseRetryAfter("120", now)
+	require.True(t, ok)
+	require.Equal(t, 2*time.Minute, got)
+
+	got, ok = parseRetryAfter("Wed, 01 Oct 2025 08:00:30 GMT", now)
+	require.True(t, ok)
+	require.Equal(t, 30*time.Second, got)
+
+	_, ok = parseRetryAfter("soon", now)
+	require.False(t, ok)
+}
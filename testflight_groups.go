@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/v2/log"
+)
+
+type betaGroupAttributes struct {
+	Name            string `json:"name"`
+	IsInternalGroup bool   `json:"isInternalGroup"`
+}
+
+type betaGroup struct {
+	id         string
+	attributes betaGroupAttributes
+}
+
+// betaGroupResult is the outcome of adding the build to a single beta group
+type betaGroupResult struct {
+	// group is the beta group name or ID, as provided in the Input
+	group string
+	err   error
+}
+
+func listBetaGroups(ctx context.Context, client *appStoreConnectClient, appID string) ([]betaGroup, error) {
+	query := url.Values{}
+	query.Set("filter[app]", appID)
+	query.Set("fields[betaGroups]", "name,isInternalGroup")
+	query.Set("limit", "200")
+
+	var response resourceCollection
+	if err := client.get(ctx, "/v1/betaGroups?"+query.Encode(), &response); err != nil {
+		return nil, err
+	}
+
+	var groups []betaGroup
+	for _, item := range response.Data {
+		var attributes betaGroupAttributes
+		if err := json.Unmarshal(item.Attributes, &attributes); err != nil {
+			return nil, fmt.Errorf("failed to decode beta group: %w", err)
+		}
+		groups = append(groups, betaGroup{id: item.ID, attributes: attributes})
+	}
+
+	return groups, nil
+}
+
+// findBetaGroup matches the beta group by ID first, then by name (case-insensitive)
+func findBetaGroup(groups []betaGroup, nameOrID string) (betaGroup, bool) {
+	for _, group := range groups {
+		if group.id == nameOrID {
+			return group, true
+		}
+	}
+	for _, group := range groups {
+		if strings.EqualFold(group.attributes.Name, nameOrID) {
+			return group, true
+		}
+	}
+
+	return betaGroup{}, false
+}
+
+func addBuildToBetaGroup(ctx context.Context, client *appStoreConnectClient, groupID, buildID string) error {
+	request := relationship{Data: []resourceIdentifier{{Type: "builds", ID: buildID}}}
+
+	return client.post(ctx, "/v1/betaGroups/"+groupID+"/relationships/builds", request, nil)
+}
+
+// distributeToBetaGroups adds the processed build to each of the given beta groups (names or IDs),
+// a failure with one group does not prevent adding the build to the others.
+func distributeToBetaGroups(ctx context.Context, logger log.Logger, client *appStoreConnectClient, appID string, b build, groupNamesOrIDs []string) []betaGroupResult {
+	var results []betaGroupResult
+	failAll := func(err error) []betaGroupResult {
+		for _, group := range groupNamesOrIDs {
+			results = append(results, betaGroupResult{group: group, err: err})
+		}
+		return results
+	}
+
+	if b.attributes.ProcessingState != processingStateValid {
+		return failAll(uploadError{
+			description: "Build not yet processed",
+			reason:      fmt.Sprintf("Only builds in %s processing state can be added to beta groups, current state: %s", processingStateValid, b.attributes.ProcessingState),
+		})
+	}
+
+	groups, err := listBetaGroups(ctx, client, appID)
+	if err != nil {
+		return failAll(fmt.Errorf("failed to list beta groups: %w", err))
+	}
+
+	for _, nameOrID := range groupNamesOrIDs {
+		group, ok := findBetaGroup(groups, nameOrID)
+		if !ok {
+			var available []string
+			for _, group := range groups {
+				available = append(available, fmt.Sprintf("%s (%s)", group.attributes.Name, group.id))
+			}
+			results = append(results, betaGroupResult{group: nameOrID, err: uploadError{
+				description: fmt.Sprintf("Beta group not found: %s", nameOrID),
+				reason:      fmt.Sprintf("Available beta groups: %s", strings.Join(available, ", ")),
+			}})
+			continue
+		}
+
+		logger.Debugf("Adding build %s to beta group %s (%s)", b.id, group.attributes.Name, group.id)
+		results = append(results, betaGroupResult{group: nameOrID, err: addBuildToBetaGroup(ctx, client, group.id, b.id)})
+	}
+
+	return results
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bitrise-io/go-utils/v2/log"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_distributeToBetaGroups(t *testing.T) {
+	var addedTo []string
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/betaGroups", func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "1023456789", r.URL.Query().Get("filter[app]"))
+		writeJSON(t, w, http.StatusOK, `{"data": [
+			{"type": "betaGroups", "id": "group-1", "attributes": {"name": "Internal Testers", "isInternalGroup": true}},
+			{"type": "betaGroups", "id": "group-2", "attributes": {"name": "External Testers", "isInternalGroup": false}},
+			{"type": "betaGroups", "id": "group-3", "attributes": {"name": "Locked", "isInternalGroup": false}}
+		]}`)
+	})
+	mux.HandleFunc("POST /v1/betaGroups/{id}/relationships/builds", func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			Data []resourceIdentifier `json:"data"`
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&request))
+		require.Equal(t, []resourceIdentifier{{Type: "builds", ID: "build-1"}}, request.Data)
+
+		if r.PathValue("id") == "group-3" {
+			writeJSON(t, w, http.StatusUnprocessableEntity, `{"errors": [{"status": "422", "code": "ENTITY_ERROR", "title": "The build cannot be added", "detail": "Missing export compliance information."}]}`)
+			return
+		}
+		addedTo = append(addedTo, r.PathValue("id"))
+		w.WriteHeader(http.StatusNoContent)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	client := newAppStoreConnectClient(server.Client(), server.URL, staticTokenSource("test-token"))
+
+	processed := build{id: "build-1", attributes: buildAttributes{ProcessingState: processingStateValid}}
+	results := distributeToBetaGroups(context.Background(), log.NewLogger(), client, "1023456789", processed, []string{"internal testers", "group-2", "Missing", "Locked"})
+
+	require.Equal(t, []string{"group-1", "group-2"}, addedTo)
+	require.Len(t, results, 4)
+	require.NoError(t, results[0].err)
+	require.NoError(t, results[1].err)
+	require.EqualError(t, results[2].err, "Beta group not found: Missing  Available beta groups: Internal Testers (group-1), External Testers (group-2), Locked (group-3)")
+	require.EqualError(t, results[3].err, "The build cannot be added (422)  Missing export compliance information.  (code: ENTITY_ERROR)")
+
+	notProcessed := build{id: "build-1", attributes: buildAttributes{ProcessingState: processingStateProcessing}}
+	results = distributeToBetaGroups(context.Background(), log.NewLogger(), client, "1023456789", notProcessed, []string{"group-1"})
+
+	require.Equal(t, []betaGroupResult{{group: "group-1", err: uploadError{
+		description: "Build not yet processed",
+		reason:      "Only builds in VALID processing state can be added to beta groups, current state: PROCESSING",
+	}}}, results)
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/bitrise-io/go-utils/v2/log"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// defaultWhatsNewLocale is the locale of the What to Test notes provided as a single text
+	defaultWhatsNewLocale = "en-US"
+	whatsNewMaxLength     = 4000
+)
+
+// betaBuildLocales are the locales supported by App Store Connect for TestFlight build localizations
+var betaBuildLocales = []string{
+	"ar-SA", "ca", "cs", "da", "de-DE", "el", "en-AU", "en-CA", "en-GB", "en-US", "es-ES", "es-MX", "fi", "fr-CA",
+	"fr-FR", "he", "hi", "hr", "hu", "id", "it", "ja", "ko", "ms", "nl-NL", "no", "pl", "pt-BR", "pt-PT", "ro",
+	"ru", "sk", "sv", "th", "tr", "uk", "vi", "zh-Hans", "zh-Hant",
+}
+
+type betaBuildLocalizationAttributes struct {
+	Locale   string `json:"locale,omitempty"`
+	WhatsNew string `json:"whatsNew"`
+}
+
+// parseWhatsNew returns the What to Test notes by locale,
+// the notes file (JSON or YAML, mapping locales to text) overrides the default notes.
+func parseWhatsNew(defaultNotes, notesFilePth string) (map[string]string, error) {
+	notes := map[string]string{}
+	if strings.TrimSpace(defaultNotes) != "" {
+		notes[defaultWhatsNewLocale] = defaultNotes
+	}
+
+	if notesFilePth == "" {
+		return notes, nil
+	}
+
+	content, err := os.ReadFile(notesFilePth)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read What to Test notes file: %w", err)
+	}
+	// JSON is valid YAML, so both formats are parsed as YAML
+	var localizedNotes map[string]string
+	if err := yaml.Unmarshal(content, &localizedNotes); err != nil {
+		return nil, fmt.Errorf("failed to parse What to Test notes file (%s), expected a locale to text mapping: %w", notesFilePth, err)
+	}
+	for locale, text := range localizedNotes {
+		notes[locale] = text
+	}
+
+	return notes, nil
+}
+
+// validateWhatsNew checks the locale codes and the length of the notes, all problems are reported at once
+func validateWhatsNew(notes map[string]string) error {
+	var errs []error
+	for _, locale := range sortedKeys(notes) {
+		if !slices.Contains(betaBuildLocales, locale) {
+			errs = append(errs, fmt.Errorf("unsupported locale: %s (supported locales: %s)", locale, strings.Join(betaBuildLocales, ", ")))
+		}
+		if length := utf8.RuneCountInString(notes[locale]); length > whatsNewMaxLength {
+			errs = append(errs, fmt.Errorf("notes for %s are too long: %d characters, maximum is %d", locale, length, whatsNewMaxLength))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// updateBetaBuildLocalizations creates or updates the What to Test notes of the build for each locale
+func updateBetaBuildLocalizations(ctx context.Context, logger log.Logger, client *appStoreConnectClient, buildID string, notes map[string]string) error {
+	query := url.Values{}
+	query.Set("fields[betaBuildLocalizations]", "locale,whatsNew")
+	query.Set("limit", "200")
+
+	var response resourceCollection
+	if err := client.get(ctx, "/v1/builds/"+buildID+"/betaBuildLocalizations?"+query.Encode(), &response); err != nil {
+		return fmt.Errorf("failed to list build localizations: %w", err)
+	}
+
+	existing := map[string]string{}
+	for _, item := range response.Data {
+		var attributes betaBuildLocalizationAttributes
+		if err := json.Unmarshal(item.Attributes, &attributes); err != nil {
+			return fmt.Errorf("failed to decode build localization: %w", err)
+		}
+		existing[attributes.Locale] = item.ID
+	}
+
+	for _, locale := range sortedKeys(notes) {
+		if localizationID, ok := existing[locale]; ok {
+			logger.Printf("Updating What to Test notes (%s)", locale)
+			if err := updateBetaBuildLocalization(ctx, client, localizationID, notes[locale]); err != nil {
+				return fmt.Errorf("failed to update What to Test notes (%s): %w", locale, err)
+			}
+		} else {
+			logger.Printf("Creating What to Test notes (%s)", locale)
+			if err := createBetaBuildLocalization(ctx, client, buildID, locale, notes[locale]); err != nil {
+				return fmt.Errorf("failed to create What to Test notes (%s): %w", locale, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+func createBetaBuildLocalization(ctx context.Context, client *appStoreConnectClient, buildID, locale, whatsNew string) error {
+	attributes, err := json.Marshal(betaBuildLocalizationAttributes{Locale: locale, WhatsNew: whatsNew})
+	if err != nil {
+		return err
+	}
+
+	request := resourceDocument{Data: resource{
+		Type:       "betaBuildLocalizations",
+		Attributes: attributes,
+		Relationships: map[string]relationship{
+			"build": {Data: resourceIdentifier{Type: "builds", ID: buildID}},
+		},
+	}}
+
+	return client.post(ctx, "/v1/betaBuildLocalizations", request, nil)
+}
+
+func updateBetaBuildLocalization(ctx context.Context, client *appStoreConnectClient, localizationID, whatsNew string) error {
+	attributes, err := json.Marshal(betaBuildLocalizationAttributes{WhatsNew: whatsNew})
+	if err != nil {
+		return err
+	}
+
+	request := resourceDocument{Data: resource{
+		Type:       "betaBuildLocalizations",
+		ID:         localizationID,
+		Attributes: attributes,
+	}}
+
+	return client.patch(ctx, "/v1/betaBuildLocalizations/"+localizationID, request, nil)
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
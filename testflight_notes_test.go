@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bitrise-io/go-utils/v2/log"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_parseWhatsNew(t *testing.T) {
+	dir := t.TempDir()
+	yamlPth := filepath.Join(dir, "notes.yml")
+	require.NoError(t, os.WriteFile(yamlPth, []byte("en-US: |\n  Bug fixes.\n  New login screen.\nde-DE: Fehlerbehebungen.\n"), 0600))
+	jsonPth := filepath.Join(dir, "notes.json")
+	require.NoError(t, os.WriteFile(jsonPth, []byte(`{"fr-FR": "Corrections de bugs."}`), 0600))
+	invalidPth := filepath.Join(dir, "invalid.yml")
+	require.NoError(t, os.WriteFile(invalidPth, []byte("- a list\n- of notes\n"), 0600))
+
+	tests := []struct {
+		name         string
+		defaultNotes string
+		notesFilePth string
+		want         map[string]string
+		wantErr      bool
+	}{
+		{name: "No notes", want: map[string]string{}},
+		{name: "Default notes", defaultNotes: "Bug fixes.", want: map[string]string{"en-US": "Bug fixes."}},
+		{
+			name:         "YAML file overrides default notes",
+			defaultNotes: "Bug fixes.",
+			notesFilePth: yamlPth,
+			want:         map[string]string{"en-US": "Bug fixes.\nNew login screen.\n", "de-DE": "Fehlerbehebungen."},
+		},
+		{
+			name:         "JSON file",
+			defaultNotes: "Bug fixes.",
+			notesFilePth: jsonPth,
+			want:         map[string]string{"en-US": "Bug fixes.", "fr-FR": "Corrections de bugs."},
+		},
+		{name: "Not a mapping", notesFilePth: invalidPth, wantErr: true},
+		{name: "Missing file", notesFilePth: filepath.Join(dir, "missing.yml"), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseWhatsNew(tt.defaultNotes, tt.notesFilePth)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_validateWhatsNew(t *testing.T) {
+	require.NoError(t, validateWhatsNew(map[string]string{"en-US": strings.Repeat("á", 4000), "zh-Hans": "修复"}))
+
+	err := validateWhatsNew(map[string]string{"en": "Bug fixes.", "de-DE": strings.Repeat("a", 4001)})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "notes for de-DE are too long: 4001 characters, maximum is 4000")
+	require.Contains(t, err.Error(), "unsupported locale: en (supported locales: ar-SA, ca,")
+}
+
+func Test_updateBetaBuildLocalizations(t *testing.T) {
+	var updated, created []betaBuildLocalizationAttributes
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/builds/build-1/betaBuildLocalizations", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(t, w, http.StatusOK, `{"data": [{"type": "betaBuildLocalizations", "id": "loc-en", "attributes": {"locale": "en-US", "whatsNew": "Old notes."}}]}`)
+	})
+	mux.HandleFunc("PATCH /v1/betaBuildLocalizations/loc-en", func(w http.ResponseWriter, r *http.Request) {
+		var request resourceDocument
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&request))
+		var attributes betaBuildLocalizationAttributes
+		require.NoError(t, json.Unmarshal(request.Data.Attributes, &attributes))
+		updated = append(updated, attributes)
+		writeJSON(t, w, http.StatusOK, `{"data": {"type": "betaBuildLocalizations", "id": "loc-en"}}`)
+	})
+	mux.HandleFunc("POST /v1/betaBuildLocalizations", func(w http.ResponseWriter, r *http.Request) {
+		var request resourceDocument
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&request))
+		require.Equal(t, map[string]interface{}{"type": "builds", "id": "build-1"}, request.Data.Relationships["build"].Data)
+		var attributes betaBuildLocalizationAttributes
+		require.NoError(t, json.Unmarshal(request.Data.Attributes, &attributes))
+		created = append(created, attributes)
+		writeJSON(t, w, http.StatusCreated, `{"data": {"type": "betaBuildLocalizations", "id": "loc-de"}}`)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	client := newAppStoreConnectClient(server.Client(), server.URL, staticTokenSource("test-token"))
+
+	err := updateBetaBuildLocalizations(context.Background(), log.NewLogger(), client, "build-1", map[string]string{"en-US": "Bug fixes.", "de-DE": "Fehlerbehebungen."})
+
+	require.NoError(t, err)
+	require.Equal(t, []betaBuildLocalizationAttributes{{WhatsNew: "Bug fixes."}}, updated)
+	require.Equal(t, []betaBuildLocalizationAttributes{{Locale: "de-DE", WhatsNew: "Fehlerbehebungen."}}, created)
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"compress/zlib"
+	"encoding/binary"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+)
+
+// xar is the archive format of installer packages, see https://github.com/apple-oss-distributions/xar
+//
+//	header (big endian): magic "xar!", header size (uint16), version (uint16),
+//	                     compressed TOC length (uint64), uncompressed TOC length (uint64), checksum algorithm (uint32)
+//	TOC: zlib compressed XML, listing the files and their location in the heap
+//	heap: the file contents, starting right after the TOC
+const (
+	xarMagic         = "xar!"
+	xarHeaderMinSize = 28
+	// xarMaxTOCSize limits the memory used by a corrupt archive, TOCs of app packages are a few kilobytes
+	xarMaxTOCSize = 16 << 20
+)
+
+type xarHeader struct {
+	Magic                 [4]byte
+	Size                  uint16
+	Version               uint16
+	TOCLengthCompressed   uint64
+	TOCLengthUncompressed uint64
+	ChecksumAlgorithm     uint32
+}
+
+type xarTOC struct {
+	Checksum   *xarHeapEntry `xml:"toc>checksum"`
+	Signature  *xarSignature `xml:"toc>signature"`
+	XSignature *xarSignature `xml:"toc>x-signature"`
+	Files      []xarFile     `xml:"toc>file"`
+}
+
+// xarHeapEntry is the location of the TOC checksum or signature in the heap
+type xarHeapEntry struct {
+	Style  string `xml:"style,attr"`
+	Offset int64  `xml:"offset"`
+	Size   int64  `xml:"size"`
+}
+
+// xarSignature is the signature of the TOC checksum, and the certificate chain of the signer, starting with the leaf
+//
+//	<signature style="RSA">
+//	  <offset>20</offset>
+//	  <size>256</size>
+//	  <KeyInfo xmlns="http://www.w3.org/2000/09/xmldsig#">
+//	    <X509Data><X509Certificate>MIIF...</X509Certificate>...</X509Data>
+//	  </KeyInfo>
+//	</signature>
+type xarSignature struct {
+	xarHeapEntry
+	Certificates []string `xml:"KeyInfo>X509Data>X509Certificate"`
+}
+
+type xarFile struct {
+	Name  string    `xml:"name"`
+	Type  string    `xml:"type"`
+	Data  *xarData  `xml:"data"`
+	Files []xarFile `xml:"file"`
+}
+
+type xarData struct {
+	Offset   int64 `xml:"offset"`
+	Length   int64 `xml:"length"`
+	Size     int64 `xml:"size"`
+	Encoding struct {
+		Style string `xml:"style,attr"`
+	} `xml:"encoding"`
+}
+
+// xarArchive is an opened xar archive, its files are read on demand
+type xarArchive struct {
+	file       *os.File
+	heapOffset int64
+	// tocOffset and tocLength locate the compressed TOC, its checksum is signed
+	tocOffset int64
+	tocLength int64
+	toc       xarTOC
+	// files are the regular files by their path in the archive
+	files map[string]xarData
+}
+
+func openXar(pth string) (*xarArchive, error) {
+	f, err := os.Open(pth)
+	if err != nil {
+		return nil, err
+	}
+
+	archive, err := readXar(f)
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+
+	return archive, nil
+}
+
+func readXar(f *os.File) (*xarArchive, error) {
+	var header xarHeader
+	if err := binary.Read(f, binary.BigEndian, &header); err != nil {
+		return nil, fmt.Errorf("failed to read xar header: %w", err)
+	}
+	if string(header.Magic[:]) != xarMagic {
+		return nil, errors.New("not a xar archive")
+	}
+	if header.Size < xarHeaderMinSize {
+		return nil, fmt.Errorf("invalid xar header size: %d", header.Size)
+	}
+	if header.TOCLengthCompressed > xarMaxTOCSize || header.TOCLengthUncompressed > xarMaxTOCSize {
+		return nil, fmt.Errorf("xar TOC too large: %d bytes", header.TOCLengthUncompressed)
+	}
+
+	tocReader, err := zlib.NewReader(io.NewSectionReader(f, int64(header.Size), int64(header.TOCLengthCompressed)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read xar TOC: %w", err)
+	}
+	var toc xarTOC
+	if err := xml.NewDecoder(io.LimitReader(tocReader, xarMaxTOCSize)).Decode(&toc); err != nil {
+		return nil, fmt.Errorf("failed to parse xar TOC: %w", err)
+	}
+
+	archive := &xarArchive{
+		file:       f,
+		heapOffset: int64(header.Size) + int64(header.TOCLengthCompressed),
+		tocOffset:  int64(header.Size),
+		tocLength:  int64(header.TOCLengthCompressed),
+		toc:        toc,
+		files:      map[string]xarData{},
+	}
+	archive.addFiles("", toc.Files)
+
+	return archive, nil
+}
+
+func (a *xarArchive) addFiles(dir string, files []xarFile) {
+	for _, file := range files {
+		pth := path.Join(dir, file.Name)
+		if file.Type == "directory" {
+			a.addFiles(pth, file.Files)
+		} else if file.Type == "file" && file.Data != nil {
+			a.files[pth] = *file.Data
+		}
+	}
+}
+
+func (a *xarArchive) has(name string) bool {
+	_, ok := a.files[name]
+	return ok
+}
+
+// open returns the decoded content of the file, it is streamed, as payloads might be gigabytes
+func (a *xarArchive) open(name string) (io.Reader, error) {
+	data, ok := a.files[name]
+	if !ok {
+		return nil, fmt.Errorf("%s not found in the package", name)
+	}
+
+	archived := io.NewSectionReader(a.file, a.heapOffset+data.Offset, data.Length)
+	switch data.Encoding.Style {
+	case "", "application/octet-stream":
+		return archived, nil
+	case "application/x-gzip":
+		// Despite its name, the gzip encoding is a zlib stream
+		return zlib.NewReader(archived)
+	case "application/x-bzip2":
+		return bzip2.NewReader(archived), nil
+	default:
+		return nil, fmt.Errorf("unsupported encoding of %s: %s", name, data.Encoding.Style)
+	}
+}
+
+// readHeap returns the raw content of a heap entry, at most limit bytes
+func (a *xarArchive) readHeap(entry xarHeapEntry, limit int64) ([]byte, error) {
+	if entry.Offset < 0 || entry.Size < 0 || entry.Size > limit {
+		return nil, fmt.Errorf("invalid heap entry: offset %d, size %d", entry.Offset, entry.Size)
+	}
+
+	content := make([]byte, entry.Size)
+	if _, err := a.file.ReadAt(content, a.heapOffset+entry.Offset); err != nil {
+		return nil, err
+	}
+
+	return content, nil
+}
+
+// readAll returns the decoded content of a small file, at most limit bytes
+func (a *xarArchive) readAll(name string, limit int64) ([]byte, error) {
+	r, err := a.open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	var content bytes.Buffer
+	if n, err := io.Copy(&content, io.LimitReader(r, limit+1)); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", name, err)
+	} else if n > limit {
+		return nil, fmt.Errorf("%s is larger than %d bytes", name, limit)
+	}
+
+	return content.Bytes(), nil
+}
+
+func (a *xarArchive) close() error {
+	return a.file.Close()
+}